@@ -0,0 +1,128 @@
+package carddav
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+)
+
+// minPhoneDigits is the fewest digits a phone number needs to be matched on;
+// shorter ones are extensions or fragments
+const minPhoneDigits = 6
+
+// phoneMatchDigits is how many trailing digits of a phone number are compared,
+// so "+1 555 123 4567" matches "(555) 123-4567"
+const phoneMatchDigits = 9
+
+// existingContact is a contact that was already in the address book
+type existingContact struct {
+	href string
+	etag string // As sent in If-Match, with quotes
+	card vcard.Card
+}
+
+// existingContacts indexes the contacts already in an address book by
+// normalised email address and phone number
+type existingContacts struct {
+	byEmail map[string]*existingContact
+	byPhone map[string]*existingContact
+}
+
+// loadExisting reads every contact in an address book with an
+// addressbook-query REPORT (RFC 6352 section 8.6)
+func (u *Uploader) loadExisting(ctx context.Context, bookURL string) (*existingContacts, error) {
+	book, err := url.Parse(bookURL)
+	if err != nil {
+		return nil, err
+	}
+	dav, err := carddav.NewClient(u.client, bookURL)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := dav.QueryAddressBook(ctx, book.Path, &carddav.AddressBookQuery{
+		DataRequest: carddav.AddressDataRequest{AllProp: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address book %s: %w", bookURL, err)
+	}
+
+	existing := &existingContacts{
+		byEmail: make(map[string]*existingContact),
+		byPhone: make(map[string]*existingContact),
+	}
+	for _, object := range objects {
+		contact := &existingContact{
+			href: book.ResolveReference(&url.URL{Path: object.Path}).String(),
+			card: object.Card,
+		}
+		if object.ETag != "" {
+			contact.etag = fmt.Sprintf("%q", object.ETag)
+		}
+		existing.add(contact)
+	}
+	return existing, nil
+}
+
+// add indexes a contact under each of its email addresses and phone numbers
+// The first contact with an address keeps it.
+func (e *existingContacts) add(contact *existingContact) {
+	for _, field := range contact.card[vcard.FieldEmail] {
+		if key := normalizeEmail(field.Value); key != "" {
+			if _, taken := e.byEmail[key]; !taken {
+				e.byEmail[key] = contact
+			}
+		}
+	}
+	for _, field := range contact.card[vcard.FieldTelephone] {
+		if key := normalizePhone(field.Value); key != "" {
+			if _, taken := e.byPhone[key]; !taken {
+				e.byPhone[key] = contact
+			}
+		}
+	}
+}
+
+// find returns the existing contact sharing an email address with a card, or
+// failing that a phone number, and the address or number that matched
+func (e *existingContacts) find(card vcard.Card) (*existingContact, string) {
+	for _, field := range card[vcard.FieldEmail] {
+		if contact, ok := e.byEmail[normalizeEmail(field.Value)]; ok {
+			return contact, field.Value
+		}
+	}
+	for _, field := range card[vcard.FieldTelephone] {
+		if contact, ok := e.byPhone[normalizePhone(field.Value)]; ok {
+			return contact, field.Value
+		}
+	}
+	return nil, ""
+}
+
+// normalizeEmail lowercases an email address and drops any mailto: prefix
+func normalizeEmail(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return strings.TrimPrefix(value, "mailto:")
+}
+
+// normalizePhone keeps the last phoneMatchDigits digits of a phone number,
+// returning "" if it has fewer than minPhoneDigits
+func normalizePhone(value string) string {
+	var digits []rune
+	for _, r := range strings.TrimPrefix(value, "tel:") {
+		if unicode.IsDigit(r) {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) < minPhoneDigits {
+		return ""
+	}
+	if len(digits) > phoneMatchDigits {
+		digits = digits[len(digits)-phoneMatchDigits:]
+	}
+	return string(digits)
+}
@@ -0,0 +1,260 @@
+package carddav
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-webdav/carddav"
+)
+
+// Server describes the CardDAV server to upload contacts to
+// The zero value is the MXGuardian address book.
+//
+// URL may be the address book itself, the collection holding the user's
+// address books, or just the server, as "https://dav.example.com/" or
+// "example.com". Anything other than an address book is resolved by RFC 6764
+// discovery: DNS SRV/TXT records for a bare domain, /.well-known/carddav,
+// then the current-user-principal and its addressbook-home-set.
+type Server struct {
+	URL string
+
+	// TLS settings
+	CAFile             string // PEM bundle to trust instead of the system roots
+	InsecureSkipVerify bool   // Don't verify the server certificate
+}
+
+// ErrConnection is returned when the server can't be reached or the address
+// book can't be found, wrapping the underlying error
+var ErrConnection = errors.New("failed to connect to CardDAV server")
+
+// tlsConfig builds the TLS configuration for the server
+func (s Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", s.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// httpClient builds the HTTP client for the server
+// Redirects aren't followed, as Go would turn a PROPFIND into a GET; discovery
+// follows them itself.
+func (s Server) httpClient() (*http.Client, error) {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// authClient adds basic authentication to requests and turns rejected
+// credentials into ErrAuth, which go-webdav would otherwise hide
+type authClient struct {
+	client             *http.Client
+	username, password string
+}
+
+func (c *authClient) Do(req *http.Request) (*http.Response, error) {
+	req.SetBasicAuth(c.username, c.password)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrAuth, resp.Status)
+	}
+	return resp, nil
+}
+
+// addressBooks is where discovery found the user's address books
+type addressBooks struct {
+	book string // Address book URL, with a trailing slash
+	home string // Collection holding the user's address books, with a trailing slash
+}
+
+// discover finds the address book to upload to from the server URL
+func discover(ctx context.Context, client *authClient, serverURL string) (*addressBooks, error) {
+	if serverURL == "" {
+		serverURL = CardDAVServer
+	}
+
+	// A bare domain: look for SRV/TXT records, else try the domain over HTTPS
+	if !strings.Contains(serverURL, "://") {
+		domain := strings.TrimSuffix(serverURL, "/")
+		if contextURL, err := carddav.DiscoverContextURL(ctx, domain); err == nil {
+			serverURL = contextURL
+		} else {
+			serverURL = "https://" + domain + "/"
+		}
+	}
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CardDAV URL %q: %w", serverURL, err)
+	}
+	if base.Scheme != "https" && base.Scheme != "http" {
+		return nil, fmt.Errorf("invalid CardDAV URL %q: scheme must be https or http", serverURL)
+	}
+	if base.Path == "" {
+		base.Path = "/"
+	}
+
+	// The URL may already be an address book, or the collection of them
+	if books, err := findAddressBooks(ctx, client, base, base.Path); err == nil {
+		if found := books.pick(base); found != nil {
+			return found, nil
+		}
+	} else if errors.Is(err, ErrAuth) || ctx.Err() != nil {
+		return nil, err
+	}
+
+	// RFC 6764 section 6: the context path, then the well-known URI
+	contexts := []string{base.Path}
+	if base.Path != "/.well-known/carddav" {
+		contexts = append(contexts, "/.well-known/carddav")
+	}
+	var lastErr error
+	for _, contextPath := range contexts {
+		contextURL, err := resolveRedirects(ctx, client, base.ResolveReference(&url.URL{Path: contextPath}))
+		if err != nil {
+			lastErr = err
+			if errors.Is(err, ErrAuth) || ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		books, err := findFromPrincipal(ctx, client, contextURL)
+		if err == nil {
+			return books, nil
+		}
+		lastErr = err
+		if errors.Is(err, ErrAuth) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no address book found at %s: %w", serverURL, lastErr)
+}
+
+// resolveRedirects follows redirects from a context URL, such as
+// /.well-known/carddav, with PROPFIND requests
+func resolveRedirects(ctx context.Context, client *authClient, target *url.URL) (*url.URL, error) {
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequestWithContext(ctx, "PROPFIND", target.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Depth", "0")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+			return target, nil
+		}
+		next, err := target.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("bad redirect from %s: %w", target, err)
+		}
+		target = next
+	}
+	return nil, fmt.Errorf("too many redirects from %s", target)
+}
+
+// findFromPrincipal finds the user's address books through the
+// current-user-principal and addressbook-home-set properties (RFC 6352
+// section 7)
+func findFromPrincipal(ctx context.Context, client *authClient, contextURL *url.URL) (*addressBooks, error) {
+	dav, err := carddav.NewClient(client, contextURL.String())
+	if err != nil {
+		return nil, err
+	}
+	principal, err := dav.FindCurrentUserPrincipal(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find principal: %w", err)
+	}
+	home, err := dav.FindAddressBookHomeSet(ctx, principal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find address book home: %w", err)
+	}
+	books, err := findAddressBooks(ctx, client, contextURL, home)
+	if err != nil {
+		return nil, err
+	}
+	found := books.pick(contextURL.ResolveReference(&url.URL{Path: home}))
+	if found == nil {
+		return nil, fmt.Errorf("no address books in %s", home)
+	}
+	return found, nil
+}
+
+// foundBooks is the result of listing a collection
+type foundBooks struct {
+	base  *url.URL
+	books []carddav.AddressBook
+}
+
+// findAddressBooks lists the address books at a path, the collection itself
+// included when it is one
+func findAddressBooks(ctx context.Context, client *authClient, base *url.URL, collection string) (*foundBooks, error) {
+	dav, err := carddav.NewClient(client, base.String())
+	if err != nil {
+		return nil, err
+	}
+	books, err := dav.FindAddressBooks(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	return &foundBooks{base: base, books: books}, nil
+}
+
+// pick chooses the address book to upload to from a listing of collection:
+// the collection itself if it is an address book, otherwise the first one in it
+func (f *foundBooks) pick(collection *url.URL) *addressBooks {
+	if len(f.books) == 0 {
+		return nil
+	}
+	collectionPath := withSlash(collection.Path)
+
+	book := f.books[0].Path
+	for _, b := range f.books {
+		if withSlash(b.Path) == collectionPath {
+			book = b.Path
+			break
+		}
+	}
+	bookURL := f.base.ResolveReference(&url.URL{Path: withSlash(book)})
+	homeURL := bookURL.ResolveReference(&url.URL{Path: "../"})
+	return &addressBooks{book: bookURL.String(), home: homeURL.String()}
+}
+
+// withSlash adds a trailing slash to a collection path
+func withSlash(p string) string {
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
@@ -2,9 +2,15 @@ package carddav
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
 
 	"github.com/emersion/go-vcard"
 
@@ -15,97 +21,601 @@ const (
 	CardDAVServer = "https://webmail.mxguardian.net/dav.php/addressbooks/AddressBook/"
 )
 
+// How contacts from several PST contacts folders are stored
+const (
+	// AddressBooksMerged puts every contact in the one address book, with the
+	// name of any folder other than the main Contacts folder as a CATEGORIES
+	// value
+	AddressBooksMerged = "merged"
+
+	// AddressBooksPerFolder gives each contacts folder other than the main
+	// one an address book of its own next to the configured one, created if
+	// it doesn't exist
+	AddressBooksPerFolder = "per-folder"
+)
+
+// What Upload does with a contact that was changed or removed on the server
+// since it was uploaded, or that is already there on a first upload
+const (
+	// ConflictSkip leaves the server copy alone
+	ConflictSkip = "skip"
+
+	// ConflictOverwrite replaces the server copy with the one from the PST
+	ConflictOverwrite = "overwrite"
+
+	// ConflictMerge keeps the server copy, adding the fields and values only
+	// the PST has
+	ConflictMerge = "merge"
+)
+
+// ErrAuth is returned by Upload when the server rejects the credentials
+var ErrAuth = errors.New("CardDAV authentication failed")
+
+// ErrConflict is returned by Upload when the contact was left alone under
+// ConflictSkip because the server copy has changed
+var ErrConflict = errors.New("contact changed on server")
+
+// errPrecondition is a 412 Precondition Failed response to a conditional PUT
+var errPrecondition = errors.New("precondition failed")
+
+// ETagStore remembers where each contact was stored on the server and its
+// ETag there, so a later upload only replaces a copy nobody has changed
+// merged is set for contacts merged into one that was already on the server,
+// which later uploads merge into again rather than replace.
+type ETagStore interface {
+	ContactETag(uid string) (href, etag string, merged, ok bool)
+	SetContactETag(uid, href, etag string, merged bool) // An empty href forgets the contact
+}
+
+// Action is what Upload did with a contact
+type Action string
+
+// Upload actions
+const (
+	ActionCreated Action = "created"
+	ActionUpdated Action = "updated" // Replaced the copy from an earlier import
+	ActionMerged  Action = "merged"  // Merged into a contact already on the server
+	ActionSkipped Action = "skipped" // Changed on the server; Upload returned ErrConflict
+)
+
+// Outcome describes what Upload did with a contact
+type Outcome struct {
+	Action Action
+	Href   string // Where the contact is on the server
+	ETag   string // Its ETag there, if known
+
+	// With ActionMerged, the server contact merged into and the email
+	// address or phone number it was matched on
+	MergedWith string
+	MatchedOn  string
+}
+
 // Uploader handles uploading contacts to CardDAV
 type Uploader struct {
-	client   *http.Client
-	baseURL  string
-	username string
-	password string
+	client  *authClient
+	baseURL string // Address book for the main Contacts folder
+	homeURL string // Collection per-folder address books are created in
+
+	perFolder bool
+	books     map[string]string // Address book URL by PST folder, once created
+
+	conflict string    // ConflictSkip, ConflictOverwrite or ConflictMerge
+	etags    ETagStore // nil treats every upload as a first upload
+
+	match    bool                         // Merge new contacts into existing ones with the same email or phone
+	existing map[string]*existingContacts // By address book URL, read on first use
 }
 
-// NewUploader creates a new CardDAV uploader
-func NewUploader(username, password string) (*Uploader, error) {
-	// Create HTTP client with TLS (skip verify like the Python script)
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+// NewUploader creates a new CardDAV uploader, locating the address book
+// described by server
+// ctx bounds the discovery requests
+func NewUploader(ctx context.Context, server Server, username, password string) (*Uploader, error) {
+	httpClient, err := server.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	client := &authClient{client: httpClient, username: username, password: password}
+
+	found, err := discover(ctx, client, server.URL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, ErrAuth) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
 	}
 
 	return &Uploader{
-		client:   httpClient,
-		baseURL:  CardDAVServer,
-		username: username,
-		password: password,
+		client:   client,
+		baseURL:  found.book,
+		homeURL:  found.home,
+		books:    make(map[string]string),
+		conflict: ConflictSkip,
+		existing: make(map[string]*existingContacts),
 	}, nil
 }
 
+// AddressBook returns the URL of the address book contacts from the main
+// Contacts folder are uploaded to
+func (u *Uploader) AddressBook() string {
+	return u.baseURL
+}
+
+// SetAddressBooks chooses how contacts from several PST folders are stored:
+// AddressBooksMerged (the default) or AddressBooksPerFolder
+func (u *Uploader) SetAddressBooks(mode string) error {
+	switch mode {
+	case "", AddressBooksMerged:
+		u.perFolder = false
+	case AddressBooksPerFolder:
+		u.perFolder = true
+	default:
+		return fmt.Errorf("unknown address book mode %q (use %s or %s)", mode, AddressBooksMerged, AddressBooksPerFolder)
+	}
+	return nil
+}
+
+// SetConflictPolicy chooses what Upload does with contacts changed on the
+// server: ConflictSkip (the default), ConflictOverwrite or ConflictMerge
+func (u *Uploader) SetConflictPolicy(policy string) error {
+	switch policy {
+	case "":
+		u.conflict = ConflictSkip
+	case ConflictSkip, ConflictOverwrite, ConflictMerge:
+		u.conflict = policy
+	default:
+		return fmt.Errorf("unknown conflict policy %q (use %s, %s or %s)", policy, ConflictSkip, ConflictOverwrite, ConflictMerge)
+	}
+	return nil
+}
+
+// SetETagStore sets where the server copy of each contact is remembered
+func (u *Uploader) SetETagStore(etags ETagStore) {
+	u.etags = etags
+}
+
+// SetMatchExisting chooses whether a contact uploaded for the first time is
+// merged into a contact already in the address book that shares an email
+// address or, failing that, a phone number, instead of being created
+func (u *Uploader) SetMatchExisting(match bool) {
+	u.match = match
+}
+
 // Upload uploads a single contact to CardDAV via HTTP PUT
-func (u *Uploader) Upload(contact *pst.Contact) error {
+// A contact seen before is only replaced if its ETag still matches (If-Match);
+// otherwise it is only created if the server doesn't have it yet
+// (If-None-Match: *). A failed condition is resolved by the conflict policy.
+// A contact stored without an ETag is treated the same as a new one, as
+// whether it changed on the server can't be told.
+// With SetMatchExisting, a new contact is merged into a matching one instead.
+// The request is abandoned if ctx is done first
+func (u *Uploader) Upload(ctx context.Context, contact *pst.Contact) (Outcome, error) {
+	bookURL := u.baseURL
+	if !contact.DefaultFolder {
+		if u.perFolder {
+			var err error
+			if bookURL, err = u.addressBook(ctx, contact.Folder); err != nil {
+				return Outcome{}, err
+			}
+		} else {
+			addCategory(contact.Card, path.Base(contact.Folder))
+		}
+	}
+
+	if u.etags != nil {
+		if href, etag, merged, ok := u.etags.ContactETag(contact.UID); ok {
+			if merged {
+				return u.mergeInto(ctx, contact, href, etag)
+			}
+			if etag == "" {
+				return u.replace(ctx, contact, href, "If-None-Match", "*", ActionCreated)
+			}
+			return u.replace(ctx, contact, href, "If-Match", etag, ActionUpdated)
+		}
+	}
+
+	if u.match {
+		existing, err := u.existingContacts(ctx, bookURL)
+		if err != nil {
+			return Outcome{}, err
+		}
+		if match, matchedOn := existing.find(contact.Card); match != nil {
+			outcome, err := u.mergeInto(ctx, contact, match.href, match.etag)
+			if err != nil {
+				return outcome, err
+			}
+			// Later contacts matching the same one merge into the new version
+			match.href, match.etag = outcome.Href, outcome.ETag
+			match.card = mergeCards(match.card, contact.Card)
+			outcome.MergedWith = match.card.Value(vcard.FieldFormattedName)
+			outcome.MatchedOn = matchedOn
+			return outcome, nil
+		}
+	}
+
+	outcome, err := u.replace(ctx, contact, bookURL+contact.UID+".vcf", "If-None-Match", "*", ActionCreated)
+	if err == nil && u.match {
+		// Later duplicates in the PST merge into this one
+		u.existing[bookURL].add(&existingContact{href: outcome.Href, etag: outcome.ETag, card: contact.Card})
+	}
+	return outcome, err
+}
+
+// replace uploads a contact under a condition, resolving a failed condition
+// by the conflict policy
+func (u *Uploader) replace(ctx context.Context, contact *pst.Contact, href, condition, value string, action Action) (Outcome, error) {
+	card := contact.Card
+	for resolved := false; ; resolved = true {
+		newHref, etag, err := u.put(ctx, href, card, condition, value)
+		if err == nil {
+			if u.etags != nil {
+				u.etags.SetContactETag(contact.UID, newHref, etag, false)
+			}
+			return Outcome{Action: action, Href: newHref, ETag: etag}, nil
+		}
+		if !errors.Is(err, errPrecondition) || resolved {
+			return Outcome{}, err
+		}
+
+		// The server copy changed, was removed, or already exists
+		if u.conflict == ConflictSkip {
+			return Outcome{Action: ActionSkipped, Href: href}, ErrConflict
+		}
+		serverCard, serverETag, err := u.get(ctx, href)
+		if err != nil {
+			return Outcome{}, err
+		}
+		switch {
+		case serverCard == nil:
+			condition, value = "If-None-Match", "*" // Removed on the server
+		case serverETag == "":
+			condition, value = "", "" // Can't tell if it changes again
+		default:
+			condition, value = "If-Match", serverETag
+		}
+		if serverCard != nil && u.conflict == ConflictMerge {
+			card = mergeCards(serverCard, contact.Card)
+		}
+	}
+}
+
+// mergeInto merges a contact into one already on the server, keeping the
+// server's values
+// A server copy changed since etag, when that is known, counts as a conflict
+// under ConflictSkip; one removed from the server is recreated from the PST.
+func (u *Uploader) mergeInto(ctx context.Context, contact *pst.Contact, href, etag string) (Outcome, error) {
+	serverCard, serverETag, err := u.get(ctx, href)
+	if err != nil {
+		return Outcome{}, err
+	}
+	if serverCard == nil {
+		if u.etags != nil {
+			u.etags.SetContactETag(contact.UID, "", "", false)
+		}
+		return u.replace(ctx, contact, href, "If-None-Match", "*", ActionCreated)
+	}
+	if etag != "" && serverETag != etag && u.conflict == ConflictSkip {
+		return Outcome{Action: ActionSkipped, Href: href}, ErrConflict
+	}
+
+	condition, value := "If-Match", serverETag
+	if serverETag == "" {
+		condition, value = "", ""
+	}
+	newHref, newETag, err := u.put(ctx, href, mergeCards(serverCard, contact.Card), condition, value)
+	if err != nil {
+		if errors.Is(err, errPrecondition) {
+			return Outcome{}, fmt.Errorf("contact changed on server while merging: %w", err)
+		}
+		return Outcome{}, err
+	}
+	if u.etags != nil {
+		u.etags.SetContactETag(contact.UID, newHref, newETag, true)
+	}
+	return Outcome{Action: ActionMerged, Href: newHref, ETag: newETag}, nil
+}
+
+// existingContacts returns the contacts already in an address book, reading
+// them on first use
+func (u *Uploader) existingContacts(ctx context.Context, bookURL string) (*existingContacts, error) {
+	if existing, ok := u.existing[bookURL]; ok {
+		return existing, nil
+	}
+	existing, err := u.loadExisting(ctx, bookURL)
+	if err != nil {
+		return nil, err
+	}
+	u.existing[bookURL] = existing
+	return existing, nil
+}
+
+// put uploads a card with a conditional request header, returning the URL the
+// server stored it at and its new ETag, if known
+func (u *Uploader) put(ctx context.Context, href string, card vcard.Card, condition, value string) (string, string, error) {
 	// Encode vCard to bytes
 	var buf bytes.Buffer
-	enc := vcard.NewEncoder(&buf)
-	if err := enc.Encode(contact.Card); err != nil {
-		return fmt.Errorf("failed to encode vCard: %w", err)
+	if err := pst.EncodeCard(&buf, card); err != nil {
+		return "", "", fmt.Errorf("failed to encode vCard: %w", err)
 	}
 
-	// Build the full URL for this contact
-	url := u.baseURL + contact.UID + ".vcf"
-
 	// Create PUT request
-	req, err := http.NewRequest("PUT", url, &buf)
+	req, err := http.NewRequestWithContext(ctx, "PUT", href, &buf)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.SetBasicAuth(u.username, u.password)
 	req.Header.Set("Content-Type", "text/vcard; charset=utf-8")
+	if condition != "" {
+		req.Header.Set(condition, value)
+	}
 
 	// Execute request
 	resp, err := u.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to upload: %w", err)
+		return "", "", fmt.Errorf("failed to upload: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", "", errPrecondition
+	}
+
 	// Check response - 201 Created or 204 No Content are success
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%d %s", resp.StatusCode, resp.Status)
+		return "", "", fmt.Errorf("%d %s", resp.StatusCode, resp.Status)
 	}
 
-	return nil
+	// Some servers store the contact under a name of their own choosing
+	if location := resp.Header.Get("Location"); location != "" {
+		if base, err := url.Parse(href); err == nil {
+			if moved, err := base.Parse(location); err == nil {
+				href = moved.String()
+			}
+		}
+	}
+
+	// A server that changes the card as it stores it doesn't return an ETag
+	// (RFC 6352 section 6.3.2.3), so ask for it
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = u.etag(ctx, href)
+	}
+	return href, etag, nil
 }
 
-// Close is a no-op for CardDAV (HTTP is stateless)
-func (u *Uploader) Close() error {
-	return nil
+// etag looks up the current ETag of a resource, returning "" if it can't
+func (u *Uploader) etag(ctx context.Context, href string) string {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", href, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return resp.Header.Get("ETag")
 }
 
-// TestConnection tests the CardDAV connection with a PROPFIND request
-func TestConnection(username, password string) error {
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+// get downloads the server copy of a contact and its ETag
+// A contact that isn't there gives a nil card.
+func (u *Uploader) get(ctx context.Context, href string) (vcard.Card, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", href, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
 	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch server copy: %w", err)
+	}
+	defer resp.Body.Close()
 
-	req, err := http.NewRequest("PROPFIND", CardDAVServer, nil)
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, "", nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("failed to fetch server copy: %s", resp.Status)
+	}
+
+	card, err := vcard.NewDecoder(resp.Body).Decode()
 	if err != nil {
-		return err
+		return nil, "", fmt.Errorf("failed to parse server copy: %w", err)
+	}
+	return card, resp.Header.Get("ETag"), nil
+}
+
+// multiValued lists the properties a contact may have several of, whose
+// values are combined by mergeCards
+var multiValued = map[string]bool{
+	vcard.FieldEmail:             true,
+	vcard.FieldTelephone:         true,
+	vcard.FieldAddress:           true,
+	vcard.FieldURL:               true,
+	vcard.FieldIMPP:              true,
+	vcard.FieldCategories:        true,
+	vcard.FieldRelated:           true,
+	vcard.FieldMember:            true,
+	"X-ADDRESSBOOKSERVER-MEMBER": true,
+}
+
+// mergeCards combines the server copy of a contact with the one from the PST
+// The server's values win; properties it doesn't have are added, and so are
+// extra values of properties such as EMAIL and TEL.
+func mergeCards(server, local vcard.Card) vcard.Card {
+	merged := make(vcard.Card, len(server))
+	for name, fields := range server {
+		merged[name] = append([]*vcard.Field(nil), fields...)
+	}
+	for name, fields := range local {
+		existing, ok := merged[name]
+		if !ok {
+			merged[name] = fields
+			continue
+		}
+		if !multiValued[name] {
+			continue
+		}
+		for _, field := range fields {
+			if !hasValue(existing, field.Value) {
+				existing = append(existing, field)
+			}
+		}
+		merged[name] = existing
+	}
+	return merged
+}
+
+// hasValue reports whether one of the fields has a value, ignoring case and
+// surrounding space
+func hasValue(fields []*vcard.Field, value string) bool {
+	value = strings.TrimSpace(value)
+	for _, field := range fields {
+		if strings.EqualFold(strings.TrimSpace(field.Value), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// addCategory adds a value to a card's CATEGORIES unless it is already there
+func addCategory(card vcard.Card, category string) {
+	var categories []string
+	for _, existing := range card.Categories() {
+		if strings.EqualFold(existing, category) {
+			return
+		}
+		if existing != "" {
+			categories = append(categories, existing)
+		}
+	}
+	card.SetCategories(append(categories, category))
+}
+
+// addressBook returns the URL of the address book for a PST contacts folder,
+// creating it with MKCOL (RFC 5689) if it doesn't exist
+// Books are created next to the main one, named after the folder.
+func (u *Uploader) addressBook(ctx context.Context, folder string) (string, error) {
+	if bookURL, ok := u.books[folder]; ok {
+		return bookURL, nil
 	}
 
-	req.SetBasicAuth(username, password)
+	bookURL := u.homeURL + bookSlug(folder) + "/"
+
+	exists, err := u.exists(ctx, bookURL)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := u.mkcol(ctx, bookURL, path.Base(folder)); err != nil {
+			return "", fmt.Errorf("failed to create address book for %s: %w", folder, err)
+		}
+	}
+
+	u.books[folder] = bookURL
+	return bookURL, nil
+}
+
+// exists checks for a collection with a PROPFIND request
+func (u *Uploader) exists(ctx context.Context, collectionURL string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", collectionURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
 	req.Header.Set("Depth", "0")
 
-	resp, err := client.Do(req)
+	resp, err := u.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("CardDAV connection failed: %w", err)
+		return false, fmt.Errorf("failed to check address book: %w", err)
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("authentication failed")
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
 	}
+	return false, fmt.Errorf("failed to check address book: %s", resp.Status)
+}
+
+// mkcolBody is an extended MKCOL request creating an address book
+const mkcolBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:mkcol xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">
+  <D:set>
+    <D:prop>
+      <D:resourcetype><D:collection/><C:addressbook/></D:resourcetype>
+      <D:displayname>%s</D:displayname>
+    </D:prop>
+  </D:set>
+</D:mkcol>
+`
 
+// mkcol creates an address book collection with a display name
+func (u *Uploader) mkcol(ctx context.Context, collectionURL, displayName string) error {
+	var name bytes.Buffer
+	xml.EscapeText(&name, []byte(displayName))
+	body := fmt.Sprintf(mkcolBody, name.String())
+
+	req, err := http.NewRequestWithContext(ctx, "MKCOL", collectionURL, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed:
+		return nil // Already exists
+	case resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// bookSlug turns a PST folder path into an address book name for its URL:
+// the path below the PST root, lower case, with anything other than letters
+// and digits replaced by "-"
+func bookSlug(folder string) string {
+	for _, root := range []string{"Top of Personal Folders/", "Root - Mailbox/", "Root/"} {
+		folder = strings.TrimPrefix(folder, root)
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(folder) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "contacts"
+	}
+	return url.PathEscape(slug)
+}
+
+// Close is a no-op for CardDAV (HTTP is stateless)
+func (u *Uploader) Close() error {
 	return nil
 }
+
+// TestConnection checks that the address book described by server can be
+// found with the credentials
+func TestConnection(ctx context.Context, server Server, username, password string) error {
+	_, err := NewUploader(ctx, server, username, password)
+	return err
+}
@@ -0,0 +1,592 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zalando/go-keyring"
+
+	"github.com/mxguardian/pst-import-tool/internal/state"
+)
+
+// BatchJob is one import listed in a batch manifest
+type BatchJob struct {
+	ID          string `json:"id,omitempty"` // Names the job's log file; defaults to the row number, user and PST name
+	PSTFile     string `json:"pst"`
+	Username    string `json:"user"`
+	Credential  string `json:"credential"` // Where to find the password; see resolveCredential
+	SkipDeleted bool   `json:"skip_deleted,omitempty"`
+	SkipSent    bool   `json:"skip_sent,omitempty"`
+	Fresh       bool   `json:"fresh,omitempty"`
+
+	password string
+}
+
+// Batch job statuses
+const (
+	jobSucceeded   = "succeeded"
+	jobPartial     = "partial"
+	jobFailed      = "failed"
+	jobInterrupted = "interrupted"
+	jobNotStarted  = "not_started"
+)
+
+// BatchJobResult records how one batch job went
+type BatchJobResult struct {
+	ID       string  `json:"id"`
+	PSTFile  string  `json:"pst"`
+	Username string  `json:"user"`
+	Status   string  `json:"status"`
+	ExitCode int     `json:"exit_code"`
+	Error    string  `json:"error,omitempty"`
+	Result   *Result `json:"result,omitempty"`
+	LogFile  string  `json:"log_file,omitempty"`
+	Started  string  `json:"started,omitempty"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// BatchReport is the consolidated report of a batch run
+type BatchReport struct {
+	Manifest   string           `json:"manifest"`
+	Jobs       []BatchJobResult `json:"jobs"`
+	Succeeded  int              `json:"succeeded"`
+	Partial    int              `json:"partial"`
+	Failed     int              `json:"failed"`
+	NotRun     int              `json:"not_run"` // Interrupted or never started
+	Uploaded   int              `json:"uploaded"`
+	Skipped    int              `json:"skipped"`
+	Errors     int              `json:"errors"` // Failed messages and contacts
+	Seconds    float64          `json:"seconds"`
+	ExitCode   int              `json:"exit_code"`
+	LogDir     string           `json:"log_dir"`
+	StateDir   string           `json:"state_dir"`
+	Parallel   int              `json:"parallel"`
+	StartedAt  string           `json:"started_at"`
+	FinishedAt string           `json:"finished_at"`
+}
+
+// Batch runs every import in a manifest, up to opts.Parallel at a time
+// Jobs for the same mailbox run one after another, so folders shared by
+// several PSTs are created once and filled in turn. Each job keeps its own
+// resume state and writes its progress to its own log file.
+// The error is ErrPartial if any job did not fully succeed
+func Batch(opts Options) error {
+	jobs, err := readManifest(opts.Manifest)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("manifest %s lists no jobs", opts.Manifest)
+	}
+	if err := resolveCredentials(jobs); err != nil {
+		return err
+	}
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	logDir := opts.LogDir
+	if logDir == "" {
+		logDir = strings.TrimSuffix(opts.Manifest, filepath.Ext(opts.Manifest)) + "-logs"
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	out, err := newBatchOutput(opts.Output)
+	if err != nil {
+		return err
+	}
+
+	// One store for all jobs, so the catalogue is updated safely
+	store, err := openStore(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open state directory: %w", err)
+	}
+
+	interrupts := handleInterrupts(out.rep)
+	defer interrupts.stop()
+
+	report := &BatchReport{
+		Manifest:  opts.Manifest,
+		Jobs:      make([]BatchJobResult, len(jobs)),
+		LogDir:    logDir,
+		StateDir:  store.Dir(),
+		Parallel:  parallel,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	start := time.Now()
+
+	runJobs(jobs, parallel, func(i int) {
+		job := jobs[i]
+		jobResult := &report.Jobs[i]
+		*jobResult = BatchJobResult{ID: job.ID, PSTFile: job.PSTFile, Username: job.Username, Status: jobNotStarted}
+		if interrupts.Stopping() {
+			jobResult.ExitCode = ExitInterrupted
+			return
+		}
+
+		jobResult.LogFile = filepath.Join(logDir, exportFileName(job.ID)+".log")
+		jobResult.Started = time.Now().UTC().Format(time.RFC3339)
+		out.jobStart(jobResult)
+
+		jobStart := time.Now()
+		result, err := runBatchJob(opts, job, jobResult.LogFile, store, interrupts)
+		jobResult.Seconds = time.Since(jobStart).Seconds()
+		jobResult.Result = result
+		jobResult.ExitCode = ExitCode(err)
+		jobResult.Error = errorString(err)
+		switch {
+		case err == nil:
+			jobResult.Status = jobSucceeded
+		case errors.Is(err, ErrPartial):
+			jobResult.Status = jobPartial
+		case errors.Is(err, ErrInterrupted):
+			jobResult.Status = jobInterrupted
+		default:
+			jobResult.Status = jobFailed
+		}
+		out.jobEnd(jobResult)
+	})
+
+	for _, job := range report.Jobs {
+		switch job.Status {
+		case jobSucceeded:
+			report.Succeeded++
+		case jobPartial:
+			report.Partial++
+		case jobFailed:
+			report.Failed++
+		default:
+			report.NotRun++
+		}
+		if job.Result != nil {
+			report.Uploaded += job.Result.Uploaded
+			report.Skipped += job.Result.Skipped
+			report.Errors += job.Result.Failed + job.Result.ContactsFailed
+		}
+	}
+	report.Seconds = time.Since(start).Seconds()
+	report.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+
+	var result error
+	switch {
+	case interrupts.Stopping():
+		result = ErrInterrupted
+	case report.Partial > 0 || report.Failed > 0:
+		result = ErrPartial
+	}
+	report.ExitCode = ExitCode(result)
+
+	if opts.ReportFile != "" {
+		if err := writeBatchReport(opts.ReportFile, report); err != nil {
+			out.rep.Warning(fmt.Errorf("failed to write report: %w", err))
+		}
+	}
+	out.summary(report, opts.ReportFile)
+	return result
+}
+
+// runBatchJob runs one import, logging its progress to logFile
+func runBatchJob(opts Options, job BatchJob, logFile string, store *state.Store, interrupts *interruptHandler) (*Result, error) {
+	f, err := os.Create(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file: %w", err)
+	}
+	defer f.Close()
+
+	opts.PSTFile = job.PSTFile
+	opts.Username = job.Username
+	opts.Password = job.password
+	opts.Folders.SkipDeleted = opts.Folders.SkipDeleted || job.SkipDeleted
+	opts.Folders.SkipSent = opts.Folders.SkipSent || job.SkipSent
+	opts.Fresh = opts.Fresh || job.Fresh
+
+	rep, err := newReporter(opts.Output, f, f)
+	if err != nil {
+		return nil, err
+	}
+	result, err := importPST(opts, rep, store, interrupts)
+	rep.Summary(result, err)
+	return result, err
+}
+
+// runJobs calls run for each job using up to parallel goroutines
+// A job is not started while another job for the same mailbox is running
+func runJobs(jobs []BatchJob, parallel int, run func(i int)) {
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		started = make([]bool, len(jobs))
+		busy    = make(map[string]bool) // Mailboxes with a running job
+		wg      sync.WaitGroup
+	)
+	mailbox := func(i int) string {
+		return strings.ToLower(jobs[i].Username)
+	}
+
+	// next claims the first job whose mailbox is free, waiting if every
+	// remaining job is blocked, and returns -1 when none are left
+	next := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			remaining := false
+			for i := range jobs {
+				if started[i] {
+					continue
+				}
+				remaining = true
+				if !busy[mailbox(i)] {
+					started[i] = true
+					busy[mailbox(i)] = true
+					return i
+				}
+			}
+			if !remaining {
+				return -1
+			}
+			cond.Wait()
+		}
+	}
+
+	for w := 0; w < parallel && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := next(); i >= 0; i = next() {
+				run(i)
+				mu.Lock()
+				busy[mailbox(i)] = false
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// readManifest reads batch jobs from a .csv or .json manifest
+// CSV manifests have a header row naming the columns: pst, user, credential
+// and optionally id, skip_deleted, skip_sent and fresh
+func readManifest(path string) ([]BatchJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var jobs []BatchJob
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &jobs); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", path, err)
+		}
+	case ".csv":
+		if jobs, err = parseCSVManifest(data); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q (use .csv or .json)", filepath.Ext(path))
+	}
+
+	// Relative PST paths are relative to the manifest
+	base := filepath.Dir(path)
+	for i := range jobs {
+		job := &jobs[i]
+		switch {
+		case job.PSTFile == "":
+			return nil, fmt.Errorf("manifest %s: job %d has no pst", path, i+1)
+		case job.Username == "":
+			return nil, fmt.Errorf("manifest %s: job %d has no user", path, i+1)
+		case job.Credential == "":
+			return nil, fmt.Errorf("manifest %s: job %d has no credential", path, i+1)
+		}
+		if !filepath.IsAbs(job.PSTFile) {
+			job.PSTFile = filepath.Join(base, job.PSTFile)
+		}
+		if job.ID == "" {
+			job.ID = fmt.Sprintf("%03d-%s-%s", i+1, job.Username,
+				strings.TrimSuffix(filepath.Base(job.PSTFile), filepath.Ext(job.PSTFile)))
+		}
+	}
+	return jobs, nil
+}
+
+// parseCSVManifest parses a CSV manifest with a header row
+func parseCSVManifest(data []byte) ([]BatchJob, error) {
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "id", "pst", "user", "credential", "skip_deleted", "skip_sent", "fresh":
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	var jobs []BatchJob
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		flag := func(name string) (bool, error) {
+			value := field(name)
+			if value == "" {
+				return false, nil
+			}
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("line %d: invalid %s %q", line, name, value)
+			}
+			return on, nil
+		}
+
+		job := BatchJob{
+			ID:         field("id"),
+			PSTFile:    field("pst"),
+			Username:   field("user"),
+			Credential: field("credential"),
+		}
+		if job.SkipDeleted, err = flag("skip_deleted"); err != nil {
+			return nil, err
+		}
+		if job.SkipSent, err = flag("skip_sent"); err != nil {
+			return nil, err
+		}
+		if job.Fresh, err = flag("fresh"); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+}
+
+// resolveCredentials looks up every job's password before any job starts
+func resolveCredentials(jobs []BatchJob) error {
+	for i := range jobs {
+		password, err := resolveCredential(jobs[i].Credential, jobs[i].Username)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", jobs[i].ID, err)
+		}
+		jobs[i].password = password
+	}
+	return nil
+}
+
+// resolveCredential returns the password a credential reference points to,
+// so manifests never hold passwords themselves:
+//
+//	env:NAME          the environment variable NAME
+//	file:PATH         the first line of the file at PATH
+//	keyring[:SERVICE] the OS secret store entry for the user under SERVICE
+//	                  (default "pst-import")
+func resolveCredential(ref, username string) (string, error) {
+	kind, value, _ := strings.Cut(ref, ":")
+	switch kind {
+	case "env":
+		password, ok := os.LookupEnv(value)
+		if !ok || password == "" {
+			return "", fmt.Errorf("environment variable %s is not set", value)
+		}
+		return password, nil
+	case "file":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to read credential file: %w", err)
+		}
+		password, _, _ := strings.Cut(string(data), "\n")
+		password = strings.TrimSuffix(password, "\r")
+		if password == "" {
+			return "", fmt.Errorf("credential file %s is empty", value)
+		}
+		return password, nil
+	case "keyring":
+		service := value
+		if service == "" {
+			service = "pst-import"
+		}
+		password, err := keyring.Get(service, username)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s/%s from the keyring: %w", service, username, err)
+		}
+		return password, nil
+	}
+	return "", fmt.Errorf("unknown credential reference %q (use env:, file: or keyring:)", ref)
+}
+
+// batchOutput prints batch progress to stdout, as text or JSON events
+// It writes to the stdout it was created with, as os.Stdout is redirected
+// while jobs read their PSTs.
+type batchOutput struct {
+	rep  reporter      // For warnings from the interrupt handler
+	json *jsonReporter // Set for JSON output
+	w    io.Writer
+
+	mu sync.Mutex // Jobs finish concurrently
+}
+
+func newBatchOutput(format string) (*batchOutput, error) {
+	rep, err := newReporter(format, os.Stdout, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	out := &batchOutput{rep: rep, w: os.Stdout}
+	out.json, _ = rep.(*jsonReporter)
+	return out, nil
+}
+
+func (o *batchOutput) jobStart(job *BatchJobResult) {
+	if o.json != nil {
+		o.json.encode(struct {
+			Event string `json:"event"`
+			Time  string `json:"time"`
+			*BatchJobResult
+		}{"job_start", time.Now().UTC().Format(time.RFC3339), job})
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(o.w, "[%s] started: %s → %s\n", job.ID, job.PSTFile, job.Username)
+}
+
+func (o *batchOutput) jobEnd(job *BatchJobResult) {
+	if o.json != nil {
+		o.json.encode(struct {
+			Event string `json:"event"`
+			Time  string `json:"time"`
+			*BatchJobResult
+		}{"job_end", time.Now().UTC().Format(time.RFC3339), job})
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(o.w, "[%s] %s", job.ID, job.Status)
+	if job.Result != nil {
+		fmt.Fprintf(o.w, ": %d uploaded, %d skipped, %d errors", job.Result.Uploaded, job.Result.Skipped,
+			job.Result.Failed+job.Result.ContactsFailed)
+	}
+	if job.Error != "" && job.Status != jobPartial {
+		fmt.Fprintf(o.w, " (%s)", job.Error)
+	}
+	fmt.Fprintf(o.w, " [log: %s]\n", job.LogFile)
+}
+
+func (o *batchOutput) summary(report *BatchReport, reportFile string) {
+	if o.json != nil {
+		o.json.encode(struct {
+			Event string `json:"event"`
+			Time  string `json:"time"`
+			*BatchReport
+		}{"batch_summary", time.Now().UTC().Format(time.RFC3339), report})
+		return
+	}
+
+	fmt.Fprintln(o.w, "\n=====================")
+	w := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tUSER\tSTATUS\tUPLOADED\tSKIPPED\tERRORS")
+	for _, job := range report.Jobs {
+		var uploaded, skipped, failed int
+		if job.Result != nil {
+			uploaded, skipped, failed = job.Result.Uploaded, job.Result.Skipped, job.Result.Failed+job.Result.ContactsFailed
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", job.ID, job.Username, job.Status, uploaded, skipped, failed)
+	}
+	w.Flush()
+
+	fmt.Fprintf(o.w, "\n%d jobs: %d succeeded, %d partial, %d failed", len(report.Jobs), report.Succeeded, report.Partial, report.Failed)
+	if report.NotRun > 0 {
+		fmt.Fprintf(o.w, ", %d not run", report.NotRun)
+	}
+	fmt.Fprintf(o.w, " in %s\n", time.Duration(report.Seconds*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(o.w, "Logs: %s\n", report.LogDir)
+	if reportFile != "" {
+		fmt.Fprintf(o.w, "Report written to %s\n", reportFile)
+	}
+	if report.Partial > 0 || report.Failed > 0 || report.NotRun > 0 {
+		fmt.Fprintln(o.w, "Run the same batch again to resume unfinished jobs")
+	}
+}
+
+// writeBatchReport writes the report as JSON or CSV, chosen by file extension
+func writeBatchReport(path string, report *BatchReport) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var err error
+		if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	case ".csv":
+		var buf strings.Builder
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"id", "pst", "user", "status", "exit_code", "uploaded", "skipped", "failed",
+			"contacts_uploaded", "contacts_failed", "seconds", "error", "log_file", "state_path"})
+		for _, job := range report.Jobs {
+			result := job.Result
+			if result == nil {
+				result = &Result{}
+			}
+			w.Write([]string{
+				job.ID,
+				job.PSTFile,
+				job.Username,
+				job.Status,
+				strconv.Itoa(job.ExitCode),
+				strconv.Itoa(result.Uploaded),
+				strconv.Itoa(result.Skipped),
+				strconv.Itoa(result.Failed),
+				strconv.Itoa(result.ContactsUploaded),
+				strconv.Itoa(result.ContactsFailed),
+				strconv.FormatFloat(job.Seconds, 'f', 1, 64),
+				job.Error,
+				job.LogFile,
+				result.StatePath,
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		data = []byte(buf.String())
+	default:
+		return fmt.Errorf("unsupported report format %q (use .json or .csv)", filepath.Ext(path))
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
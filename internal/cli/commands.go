@@ -0,0 +1,532 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mxguardian/pst-import-tool/internal/carddav"
+	"github.com/mxguardian/pst-import-tool/internal/config"
+	"github.com/mxguardian/pst-import-tool/internal/imap"
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// command is a CLI subcommand with its own flag set
+type command struct {
+	name     string
+	synopsis string // Arguments shown in usage
+	summary  string
+	run      func(args []string)
+}
+
+// commands lists the subcommands in the order shown in help
+var commands []command
+
+func init() {
+	commands = []command{
+		{"import", "--pst <file> --user <username> --pass <password> [options]\n       pst-import import --pst <file> --dry-run [--user <username>] [--report <file>]", "Import mail and contacts from a PST file", runImport},
+		{"list", "--pst <file>", "Print the folder tree with item counts", runList},
+		{"stats", "--pst <file>", "Show sizes, date ranges and item classes", runStats},
+		{"export", "--pst <file> --out <dir> [--format eml|mbox]", "Export mail to local files", runExport},
+		{"batch", "--manifest <file.csv|file.json> [--parallel <n>] [--report <file>] [options]", "Run many imports listed in a manifest", runBatch},
+		{"contacts", "--pst <file> --user <username> --pass <password>", "Sync contacts only", runContacts},
+		{"export-contacts", "--pst <file> --out <file.vcf> | --out <dir> --split", "Export contacts to vCard files", runExportContacts},
+		{"verify", "--pst <file> --user <username> --pass <password>", "Check that every message exists on the server", runVerify},
+		{"status", "[--state-dir <dir>]", "List all known imports and their progress", runStatus},
+		{"encrypt-state", "--state-encryption passphrase|keyring [--state-dir <dir>]", "Encrypt existing plaintext state", runEncryptState},
+	}
+}
+
+// Main runs the subcommand named by args[0]
+// Arguments starting with "-" run import, for compatibility with the original
+// flag-only interface
+func Main(args []string) {
+	if len(args) == 0 {
+		printUsage()
+		os.Exit(ExitUsage)
+	}
+
+	name := args[0]
+	if strings.HasPrefix(name, "-") && name != "-h" && name != "-help" && name != "--help" {
+		runImport(args)
+		return
+	}
+	args = args[1:]
+
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 0 {
+			if cmd := findCommand(args[0]); cmd != nil {
+				cmd.run([]string{"-h"})
+				return
+			}
+		}
+		printUsage()
+		return
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(ExitUsage)
+	}
+	cmd.run(args)
+}
+
+// findCommand returns the named subcommand, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// printUsage prints the top-level help listing all subcommands
+func printUsage() {
+	fmt.Println("MXGuardian PST Import Tool")
+	fmt.Println()
+	fmt.Println("Usage: pst-import <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println()
+	fmt.Println("Run 'pst-import help <command>' for the options of a command.")
+}
+
+// newFlagSet creates the flag set for a subcommand with help output and the
+// --config and --profile flags
+func newFlagSet(name string, opts *Options) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: pst-import %s %s\n\n", cmd.name, cmd.synopsis)
+		fmt.Fprintf(out, "%s\n\n", cmd.summary)
+		fmt.Fprintln(out, "Options:")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.ConfigFile, "config", "", "Config file (default: $"+config.FileEnv+" or pst-import/config.toml in the user config dir)")
+	fs.StringVar(&opts.Profile, "profile", "", "Config profile to apply (default: $"+config.ProfileEnv+")")
+	return fs
+}
+
+// addPSTFlag registers the --pst flag
+func addPSTFlag(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.PSTFile, "pst", "", "Path to PST file (required)")
+}
+
+// addCredentialFlags registers the server login flags
+func addCredentialFlags(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.Username, "user", "", "IMAP username (required)")
+	fs.StringVar(&opts.Password, "pass", "", "IMAP password (required)")
+}
+
+// addServerFlags registers the server connection flags
+func addServerFlags(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.Server.Host, "imap-host", imap.IMAPServer, "IMAP server host")
+	fs.IntVar(&opts.Server.Port, "imap-port", imap.IMAPPort, "IMAP server port (implicit TLS)")
+	fs.StringVar(&opts.Server.Auth, "imap-auth", imap.AuthLogin, "IMAP authentication: login or plain (SASL PLAIN)")
+	fs.StringVar(&opts.Server.CAFile, "imap-ca-file", "", "PEM file of CA certificates to trust for the IMAP server")
+	fs.BoolVar(&opts.Server.InsecureSkipVerify, "imap-insecure", false, "Don't verify the IMAP server certificate")
+	fs.StringVar(&opts.CardDAV.URL, "carddav-url", carddav.CardDAVServer,
+		"CardDAV address book URL, or a server URL or domain to discover it from")
+	fs.StringVar(&opts.CardDAV.CAFile, "carddav-ca-file", "", "PEM file of CA certificates to trust for the CardDAV server")
+	fs.BoolVar(&opts.CardDAV.InsecureSkipVerify, "carddav-insecure", false, "Don't verify the CardDAV server certificate")
+	fs.StringVar(&opts.AddressBooks, "address-books", carddav.AddressBooksMerged,
+		"Contacts folders besides the main one: merged (into one book, tagged by folder) or per-folder (a book each)")
+	fs.StringVar(&opts.ContactConflict, "contact-conflict", carddav.ConflictSkip,
+		"Contacts changed on the server since they were uploaded: skip, overwrite or merge")
+	fs.BoolVar(&opts.MatchContacts, "match-contacts", false,
+		"Merge contacts into existing server contacts with the same email address or phone number instead of adding new ones")
+}
+
+// addContactFlags registers the flags shaping the vCards built from contacts
+func addContactFlags(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.VCardVersion, "vcard-version", pst.VCard30, "vCard version to write contacts in: 3.0 or 4.0")
+	fs.BoolVar(&opts.SkipPhotos, "skip-photos", false, "Leave contact pictures out of the vCards, for servers that limit card size")
+	fs.StringVar(&opts.ContactGroups, "contact-groups", pst.GroupsKind,
+		"Distribution lists as vCard groups: kind (KIND:group and MEMBER) or apple (X-ADDRESSBOOKSERVER-KIND and -MEMBER)")
+}
+
+// addFolderFlags registers the flags choosing which folders are imported
+func addFolderFlags(fs *flag.FlagSet, opts *Options) {
+	fs.BoolVar(&opts.Folders.SkipDeleted, "skip-deleted", false, "Skip Deleted Items folder")
+	fs.BoolVar(&opts.Folders.SkipSent, "skip-sent", false, "Skip Sent Items folder")
+	fs.Var((*stringList)(&opts.Folders.Include), "include",
+		"Only import folders whose full path matches this glob, or regexp with a re: prefix (repeatable)")
+	fs.Var((*stringList)(&opts.Folders.Exclude), "exclude",
+		"Skip folders whose full path matches this glob, or regexp with a re: prefix (repeatable)")
+	fs.BoolVar(&opts.Folders.IncludeDrafts, "include-drafts", false, "Import the Drafts folder")
+	fs.BoolVar(&opts.Folders.IncludeJunk, "include-junk", false, "Import the Junk E-mail folder")
+}
+
+// addFolderMapFlags registers the flags choosing the IMAP folder for each PST folder
+func addFolderMapFlags(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.FolderMapFile, "folder-map", "", "TOML file of folder mapping rules: root, flatten, overrides and rewrites")
+	fs.StringVar(&opts.FolderMap.Root, "folder-root", "", "Put every folder under this IMAP folder, e.g. INBOX.Archive.2015")
+	fs.BoolVar(&opts.FolderMap.Flatten, "flatten", false, "Drop parent folders, keeping only each folder's own name")
+}
+
+// addFilterFlags registers the flags choosing which messages are imported
+func addFilterFlags(fs *flag.FlagSet, opts *Options) {
+	f := &opts.Filter
+	fs.Var(dateValue{&f.Since}, "since", "Only messages sent on or after this date: YYYY-MM-DD, RFC 3339, or an age like 90d, 6m, 7y")
+	fs.Var(dateValue{&f.Before}, "before", "Only messages sent before this date, in the same forms as --since")
+	fs.Var((*stringList)(&f.Senders), "from", "Only messages from this address glob or domain (repeatable)")
+	fs.Var((*stringList)(&f.ExcludeSenders), "exclude-from", "Skip messages from this address glob or domain (repeatable)")
+	fs.Var((*stringList)(&f.Recipients), "to", "Only messages to, cc or bcc this address glob or domain (repeatable)")
+	fs.Var((*stringList)(&f.ExcludeRecipients), "exclude-to", "Skip messages to, cc or bcc this address glob or domain (repeatable)")
+	fs.StringVar(&f.Subject, "subject", "", "Only messages whose subject matches this regexp")
+	fs.StringVar(&f.ExcludeSubject, "exclude-subject", "", "Skip messages whose subject matches this regexp")
+	fs.Var(sizeValue{&f.MaxSize}, "max-size", "Skip messages larger than this, e.g. 25MB")
+	fs.Var((*stringList)(&f.Classes), "class", "Only items whose message class matches this glob, e.g. IPM.Note* (repeatable)")
+	fs.Var((*stringList)(&f.ExcludeClasses), "exclude-class", "Skip items whose message class matches this glob (repeatable)")
+}
+
+// addStateFlags registers the resume state flags
+func addStateFlags(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.StateDir, "state-dir", "", "Directory holding import state (default: user config dir)")
+	fs.StringVar(&opts.StateEncryption, "state-encryption", "none",
+		"Encrypt state at rest: none, passphrase (from $"+statePassphraseEnv+") or keyring")
+}
+
+// parseFlags parses args, fills flags not given from the config file, and exits
+// with usage if any required flag is still empty
+func parseFlags(fs *flag.FlagSet, args []string, opts *Options, required map[string]*string) {
+	fs.Parse(args)
+
+	cfg, err := config.Load(opts.ConfigFile, opts.Profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if err := applyConfig(fs, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ExitUsage)
+	}
+
+	if err := opts.Folders.Compile(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if err := opts.Filter.Compile(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if err := loadFolderMap(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ExitUsage)
+	}
+	if opts.AddressBooks != "" && opts.AddressBooks != carddav.AddressBooksMerged && opts.AddressBooks != carddav.AddressBooksPerFolder {
+		fmt.Fprintf(os.Stderr, "Unknown address book mode %q (use %s or %s)\n", opts.AddressBooks, carddav.AddressBooksMerged, carddav.AddressBooksPerFolder)
+		os.Exit(ExitUsage)
+	}
+	switch opts.ContactConflict {
+	case "", carddav.ConflictSkip, carddav.ConflictOverwrite, carddav.ConflictMerge:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown contact conflict policy %q (use %s, %s or %s)\n", opts.ContactConflict,
+			carddav.ConflictSkip, carddav.ConflictOverwrite, carddav.ConflictMerge)
+		os.Exit(ExitUsage)
+	}
+	if opts.ContactGroups != "" && opts.ContactGroups != pst.GroupsKind && opts.ContactGroups != pst.GroupsApple {
+		fmt.Fprintf(os.Stderr, "Unknown contact group style %q (use %s or %s)\n", opts.ContactGroups, pst.GroupsKind, pst.GroupsApple)
+		os.Exit(ExitUsage)
+	}
+	if opts.VCardVersion != "" && opts.VCardVersion != pst.VCard30 && opts.VCardVersion != pst.VCard40 {
+		fmt.Fprintf(os.Stderr, "Unknown vCard version %q (use %s or %s)\n", opts.VCardVersion, pst.VCard30, pst.VCard40)
+		os.Exit(ExitUsage)
+	}
+
+	requireFlags(fs, required)
+}
+
+// loadFolderMap reads opts.FolderMapFile into opts.FolderMap, keeping
+// --folder-root and --flatten over the file's settings, and compiles it
+func loadFolderMap(opts *Options) error {
+	if opts.FolderMapFile != "" {
+		folderMap, err := imap.LoadFolderMap(opts.FolderMapFile)
+		if err != nil {
+			return err
+		}
+		if opts.FolderMap.Root != "" {
+			folderMap.Root = opts.FolderMap.Root
+		}
+		folderMap.Flatten = folderMap.Flatten || opts.FolderMap.Flatten
+		opts.FolderMap = *folderMap
+	}
+	return opts.FolderMap.Compile()
+}
+
+// applyConfig sets each flag that was not given on the command line to its
+// config file value, if the config has one
+func applyConfig(fs *flag.FlagSet, cfg *config.Config) error {
+	values := map[string]string{
+		"user":             cfg.IMAP.Username,
+		"imap-host":        cfg.IMAP.Host,
+		"imap-auth":        cfg.IMAP.Auth,
+		"imap-ca-file":     cfg.IMAP.CAFile,
+		"carddav-url":      cfg.CardDAV.URL,
+		"carddav-ca-file":  cfg.CardDAV.CAFile,
+		"address-books":    cfg.CardDAV.AddressBooks,
+		"contact-conflict": cfg.CardDAV.Conflict,
+		"contact-groups":   cfg.CardDAV.Groups,
+		"vcard-version":    cfg.CardDAV.VCardVersion,
+		"state-dir":        cfg.State.Dir,
+		"state-encryption": cfg.State.Encryption,
+		"output":           cfg.Output.Format,
+		"log-dir":          cfg.Batch.LogDir,
+		"since":            cfg.Filters.Since,
+		"before":           cfg.Filters.Before,
+		"subject":          cfg.Filters.Subject,
+		"exclude-subject":  cfg.Filters.ExcludeSubject,
+		"max-size":         cfg.Filters.MaxSize,
+		"folder-map":       cfg.Folders.Map,
+		"folder-root":      cfg.Folders.Root,
+	}
+	if cfg.IMAP.Port != 0 {
+		values["imap-port"] = strconv.Itoa(cfg.IMAP.Port)
+	}
+	if cfg.Batch.Parallel != 0 {
+		values["parallel"] = strconv.Itoa(cfg.Batch.Parallel)
+	}
+	// Booleans can only be switched on; a flag of =false turns them off again
+	for name, on := range map[string]bool{
+		"imap-insecure":    cfg.IMAP.InsecureSkipVerify,
+		"carddav-insecure": cfg.CardDAV.InsecureSkipVerify,
+		"match-contacts":   cfg.CardDAV.MatchContacts,
+		"skip-photos":      cfg.CardDAV.SkipPhotos,
+		"skip-deleted":     cfg.Import.SkipDeleted,
+		"skip-sent":        cfg.Import.SkipSent,
+		"include-drafts":   cfg.Folders.IncludeDrafts,
+		"include-junk":     cfg.Folders.IncludeJunk,
+		"flatten":          cfg.Folders.Flatten,
+	} {
+		if on {
+			values[name] = "true"
+		}
+	}
+
+	// Repeatable flags take each value in turn
+	lists := map[string][]string{
+		"include":       cfg.Folders.Include,
+		"exclude":       cfg.Folders.Exclude,
+		"from":          cfg.Filters.From,
+		"exclude-from":  cfg.Filters.ExcludeFrom,
+		"to":            cfg.Filters.To,
+		"exclude-to":    cfg.Filters.ExcludeTo,
+		"class":         cfg.Filters.Class,
+		"exclude-class": cfg.Filters.ExcludeClass,
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		list := lists[f.Name]
+		if value := values[f.Name]; value != "" {
+			list = []string{value}
+		}
+		for _, value := range list {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("config %s: invalid value %q for %s: %w", cfg.Path(), value, f.Name, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// requireFlags exits with usage if any required flag is empty
+func requireFlags(fs *flag.FlagSet, required map[string]*string) {
+	var missing []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := required[f.Name]; ok && *value == "" {
+			missing = append(missing, "--"+f.Name)
+		}
+	})
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "Missing required option: %s\n\n", strings.Join(missing, ", "))
+		fs.Usage()
+		os.Exit(ExitUsage)
+	}
+}
+
+// exit ends a command with the exit code for err, printing err first unless
+// it only says some items failed, which the command has reported already
+func exit(err error) {
+	if err != nil && !errors.Is(err, ErrPartial) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	os.Exit(ExitCode(err))
+}
+
+func runImport(args []string) {
+	var opts Options
+	fs := newFlagSet("import", &opts)
+	addPSTFlag(fs, &opts)
+	addCredentialFlags(fs, &opts)
+	addServerFlags(fs, &opts)
+	addContactFlags(fs, &opts)
+	fs.BoolVar(&opts.Fresh, "fresh", false, "Start fresh, ignoring any saved progress")
+	addFolderFlags(fs, &opts)
+	addFilterFlags(fs, &opts)
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Report what would be uploaded without connecting or saving progress")
+	fs.StringVar(&opts.ReportFile, "report", "", "With --dry-run, also write the report to a .json or .csv file")
+	fs.StringVar(&opts.ContactsReport, "contacts-report", "", "Write what was done with each contact to a .json or .csv file")
+	fs.StringVar(&opts.Output, "output", "text", "Output format: text, or json for one JSON event per line")
+	addStateFlags(fs, &opts)
+	addFolderMapFlags(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile})
+
+	if opts.Output != "text" && opts.Output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q (use text or json)\n\n", opts.Output)
+		fs.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	// A dry run never logs in, so credentials are optional
+	if !opts.DryRun {
+		requireFlags(fs, map[string]*string{"user": &opts.Username, "pass": &opts.Password})
+	}
+
+	_, err := Run(opts)
+	if err != nil && opts.DryRun {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	os.Exit(ExitCode(err))
+}
+
+func runBatch(args []string) {
+	var opts Options
+	fs := newFlagSet("batch", &opts)
+	fs.StringVar(&opts.Manifest, "manifest", "", "CSV or JSON manifest listing pst, user and credential for each import (required)")
+	fs.IntVar(&opts.Parallel, "parallel", 1, "Number of imports to run at once")
+	fs.StringVar(&opts.LogDir, "log-dir", "", "Directory for per-job logs (default: <manifest>-logs)")
+	fs.StringVar(&opts.ReportFile, "report", "", "Also write the consolidated report to a .json or .csv file")
+	fs.StringVar(&opts.Output, "output", "text", "Output format: text, or json for one JSON event per line")
+	fs.BoolVar(&opts.Fresh, "fresh", false, "Start every job fresh, ignoring any saved progress")
+	addFolderFlags(fs, &opts)
+	addFilterFlags(fs, &opts)
+	addServerFlags(fs, &opts)
+	addContactFlags(fs, &opts)
+	addStateFlags(fs, &opts)
+	addFolderMapFlags(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"manifest": &opts.Manifest})
+
+	if opts.Output != "text" && opts.Output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q (use text or json)\n\n", opts.Output)
+		fs.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	err := Batch(opts)
+	if err != nil && !errors.Is(err, ErrPartial) && !errors.Is(err, ErrInterrupted) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	os.Exit(ExitCode(err))
+}
+
+func runList(args []string) {
+	var opts Options
+	fs := newFlagSet("list", &opts)
+	addPSTFlag(fs, &opts)
+	fs.BoolVar(&opts.ListPaths, "paths", false, "Print full folder paths, as matched by --include and --exclude")
+	fs.BoolVar(&opts.ListMapping, "mapping", false, "Print full folder paths with the IMAP folder each is uploaded to")
+	addFolderMapFlags(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile})
+
+	exit(List(opts))
+}
+
+func runStats(args []string) {
+	var opts Options
+	fs := newFlagSet("stats", &opts)
+	addPSTFlag(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile})
+
+	exit(Stats(opts))
+}
+
+func runExport(args []string) {
+	var opts Options
+	fs := newFlagSet("export", &opts)
+	addPSTFlag(fs, &opts)
+	fs.StringVar(&opts.ExportDir, "out", "", "Directory to write exported files to (required)")
+	fs.StringVar(&opts.ExportFormat, "format", "eml", "Export format: eml (one file per message) or mbox (one file per folder)")
+	addFolderFlags(fs, &opts)
+	addFilterFlags(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile, "out": &opts.ExportDir})
+
+	exit(Export(opts))
+}
+
+func runExportContacts(args []string) {
+	var opts Options
+	fs := newFlagSet("export-contacts", &opts)
+	addPSTFlag(fs, &opts)
+	fs.StringVar(&opts.ExportDir, "out", "", "File to write all contacts to, or with --split the directory to write them to (required)")
+	fs.BoolVar(&opts.ExportSplit, "split", false, "Write one .vcf file per contact instead of one file with all of them")
+	addContactFlags(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile, "out": &opts.ExportDir})
+
+	exit(ExportContacts(opts))
+}
+
+func runContacts(args []string) {
+	var opts Options
+	fs := newFlagSet("contacts", &opts)
+	addPSTFlag(fs, &opts)
+	addCredentialFlags(fs, &opts)
+	addServerFlags(fs, &opts)
+	addContactFlags(fs, &opts)
+	addStateFlags(fs, &opts)
+	fs.StringVar(&opts.ContactsReport, "contacts-report", "", "Write what was done with each contact to a .json or .csv file")
+	fs.BoolVar(&opts.Fresh, "fresh", false, "Upload contacts as if for the first time, ignoring the saved ETags")
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile, "user": &opts.Username, "pass": &opts.Password})
+
+	exit(Contacts(opts))
+}
+
+func runVerify(args []string) {
+	var opts Options
+	fs := newFlagSet("verify", &opts)
+	addPSTFlag(fs, &opts)
+	addCredentialFlags(fs, &opts)
+	addServerFlags(fs, &opts)
+	addFolderFlags(fs, &opts)
+	addFilterFlags(fs, &opts)
+	addFolderMapFlags(fs, &opts)
+	parseFlags(fs, args, &opts, map[string]*string{"pst": &opts.PSTFile, "user": &opts.Username, "pass": &opts.Password})
+
+	exit(Verify(opts))
+}
+
+func runStatus(args []string) {
+	var opts Options
+	fs := newFlagSet("status", &opts)
+	addStateFlags(fs, &opts)
+	parseFlags(fs, args, &opts, nil)
+
+	exit(Status(opts))
+}
+
+func runEncryptState(args []string) {
+	var opts Options
+	fs := newFlagSet("encrypt-state", &opts)
+	addStateFlags(fs, &opts)
+	parseFlags(fs, args, &opts, nil)
+
+	exit(EncryptState(opts))
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mxguardian/pst-import-tool/internal/carddav"
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// ContactReport lists what a contact sync did with each contact, so merges
+// into existing contacts can be reviewed
+type ContactReport struct {
+	AddressBook string               `json:"address_book"`
+	Contacts    []ContactReportEntry `json:"contacts"`
+}
+
+// ContactReportEntry describes what happened to one contact
+type ContactReportEntry struct {
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Folder     string `json:"folder"`
+	Action     string `json:"action"` // A carddav.Action, or "failed"
+	Href       string `json:"href,omitempty"`
+	MergedWith string `json:"merged_with,omitempty"` // Name on the server contact merged into
+	MatchedOn  string `json:"matched_on,omitempty"`  // Email address or phone number that matched
+	Error      string `json:"error,omitempty"`
+}
+
+// add records the outcome of one upload
+func (r *ContactReport) add(contact *pst.Contact, outcome carddav.Outcome, err error) {
+	entry := ContactReportEntry{
+		Name:       contact.Name,
+		UID:        contact.UID,
+		Folder:     contact.Folder,
+		Action:     string(outcome.Action),
+		Href:       outcome.Href,
+		MergedWith: outcome.MergedWith,
+		MatchedOn:  outcome.MatchedOn,
+		Error:      errorString(err),
+	}
+	if entry.Action == "" {
+		entry.Action = statusFailed
+	}
+	r.Contacts = append(r.Contacts, entry)
+}
+
+// writeContactReport writes the report as JSON or CSV, chosen by file extension
+func writeContactReport(path string, report *ContactReport) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var err error
+		if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	case ".csv":
+		var buf strings.Builder
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"name", "uid", "folder", "action", "href", "merged_with", "matched_on", "error"})
+		for _, entry := range report.Contacts {
+			w.Write([]string{
+				entry.Name,
+				entry.UID,
+				entry.Folder,
+				entry.Action,
+				entry.Href,
+				entry.MergedWith,
+				entry.MatchedOn,
+				entry.Error,
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		data = []byte(buf.String())
+	default:
+		return fmt.Errorf("unsupported report format %q (use .json or .csv)", filepath.Ext(path))
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+	"github.com/mxguardian/pst-import-tool/internal/state"
+)
+
+// DryRunReport describes what an import would upload
+type DryRunReport struct {
+	PSTFile     string         `json:"pst_file"`
+	Username    string         `json:"username,omitempty"`
+	Destination string         `json:"destination"`
+	Folders     []DryRunFolder `json:"folders"`
+	Messages    int            `json:"messages"`
+	Bytes       int64          `json:"bytes"`
+	Skipped     int            `json:"skipped"`
+	Filtered    int            `json:"filtered"` // Left out by the message filter
+	Contacts    int            `json:"contacts"`
+}
+
+// DryRunFolder describes what an import would do with one PST folder
+type DryRunFolder struct {
+	Folder      string         `json:"folder"`
+	Mailbox     string         `json:"mailbox,omitempty"` // Destination IMAP folder
+	Messages    int            `json:"messages"`
+	Bytes       int64          `json:"bytes"`
+	SkipReason  string         `json:"skip_reason,omitempty"` // Set when the whole folder is skipped
+	SkipReasons map[string]int `json:"skipped,omitempty"`     // Skipped item counts by reason
+}
+
+// skip records skipped items against a reason
+func (f *DryRunFolder) skip(reason string, count int) {
+	if f.SkipReasons == nil {
+		f.SkipReasons = make(map[string]int)
+	}
+	f.SkipReasons[reason] += count
+}
+
+// skippedCount returns the number of skipped items in the folder, not
+// counting those left out by the message filter
+func (f *DryRunFolder) skippedCount() int {
+	n := 0
+	for reason, count := range f.SkipReasons {
+		if !strings.HasPrefix(reason, pst.SkipFiltered) {
+			n += count
+		}
+	}
+	return n
+}
+
+// filteredCount returns the number of items left out by the message filter
+func (f *DryRunFolder) filteredCount() int {
+	n := 0
+	for reason, count := range f.SkipReasons {
+		if strings.HasPrefix(reason, pst.SkipFiltered) {
+			n += count
+		}
+	}
+	return n
+}
+
+// DryRun walks the PST exactly as an import would and reports the destination
+// mailbox, message count, size and skipped items of each folder, without
+// connecting to the server or modifying saved state
+func DryRun(opts Options) error {
+	if opts.Output != "" && opts.Output != "text" && opts.Output != "json" {
+		return fmt.Errorf("unknown output format %q (use text or json)", opts.Output)
+	}
+
+	report := &DryRunReport{
+		PSTFile:     opts.PSTFile,
+		Username:    opts.Username,
+		Destination: opts.Server.Destination(),
+	}
+
+	importState := loadStateReadOnly(opts)
+
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+	if err := setFolderTypes(&opts, extractor); err != nil {
+		return err
+	}
+
+	var current *DryRunFolder
+	folderFor := func(folderName string) *DryRunFolder {
+		if current == nil || current.Folder != folderName {
+			report.Folders = append(report.Folders, DryRunFolder{Folder: folderName})
+			current = &report.Folders[len(report.Folders)-1]
+		}
+		return current
+	}
+
+	extractor.SetSkipCallback(func(folderName, reason string, count int) {
+		if reason == pst.SkipNonEmailFolder {
+			// Reported as a skipped folder rather than skipped items
+			folder := folderFor(folderName)
+			folder.SkipReason = reason
+			folder.skip(reason, count)
+			current = nil
+			return
+		}
+		folderFor(folderName).skip(reason, count)
+	})
+
+	err = extractor.Process(
+		context.Background(),
+		func(info *pst.FolderInfo) (bool, error) {
+			folderName := info.Path
+			current = nil
+			folder := folderFor(folderName)
+			folder.Mailbox = opts.FolderMap.Map(folderName)
+
+			if reason := opts.Folders.SkipReason(info); reason != "" {
+				folder.SkipReason = reason
+				current = nil
+				return true, nil
+			}
+			if importState != nil && importState.IsFolderComplete(folderName) {
+				folder.SkipReason = "already complete"
+				current = nil
+				return true, nil
+			}
+			return false, nil
+		},
+		func(folderName string, msg *pst.Message) error {
+			folder := folderFor(folderName)
+			if importState != nil && importState.IsUploaded(msg.ID) {
+				folder.skip("already uploaded", 1)
+				return nil
+			}
+			folder.Messages++
+			folder.Bytes += int64(len(msg.Content))
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("error reading PST: %w", err)
+	}
+
+	err = extractor.ProcessContacts(context.Background(), func(contact *pst.Contact) error {
+		report.Contacts++
+		return nil
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error reading contacts: %w", err)
+	}
+
+	for _, folder := range report.Folders {
+		report.Messages += folder.Messages
+		report.Bytes += folder.Bytes
+		report.Skipped += folder.skippedCount()
+		report.Filtered += folder.filteredCount()
+	}
+
+	if opts.ReportFile != "" {
+		if err := writeDryRunReport(opts.ReportFile, report); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	if opts.Output == "json" {
+		// A single event holding the whole report
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Event string `json:"event"`
+			*DryRunReport
+		}{"dry_run", report})
+	}
+
+	printDryRunReport(os.Stdout, report)
+	if opts.ReportFile != "" {
+		fmt.Printf("\nReport written to %s\n", opts.ReportFile)
+	}
+	return nil
+}
+
+// loadStateReadOnly loads saved progress for the import, if any, so the dry run
+// can leave out messages already uploaded. Nothing is created or written.
+func loadStateReadOnly(opts Options) *state.ImportState {
+	if opts.Username == "" || opts.Fresh {
+		return nil
+	}
+
+	dir := opts.StateDir
+	if dir == "" {
+		var err error
+		if dir, err = state.DefaultDir(); err != nil {
+			return nil
+		}
+	}
+	if _, err := os.Stat(dir); err != nil {
+		// No state directory, so nothing has been imported
+		return nil
+	}
+
+	store, err := openExistingStore(opts)
+	if errors.Is(err, state.ErrNoKey) {
+		// Nothing has been saved with keyring encryption
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to open state directory: %v\n", err)
+		return nil
+	}
+	importState, err := store.NewImportState(opts.PSTFile, opts.Username, opts.Server.Destination())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to initialize state: %v\n", err)
+		return nil
+	}
+	if err := importState.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to load state: %v\n", err)
+		return nil
+	}
+	return importState
+}
+
+// printDryRunReport prints the report as a table
+func printDryRunReport(out io.Writer, report *DryRunReport) {
+	fmt.Fprintln(out, "Dry run: nothing will be uploaded")
+	fmt.Fprintf(out, "PST file:    %s\n", report.PSTFile)
+	fmt.Fprintf(out, "Destination: %s\n\n", report.Destination)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tMAILBOX\tMESSAGES\tSIZE\tSKIPPED")
+	for _, folder := range report.Folders {
+		if folder.SkipReason != "" {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\tfolder: %s\n", folder.Folder, folder.Mailbox, folder.SkipReason)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", folder.Folder, folder.Mailbox, folder.Messages,
+			formatBytes(folder.Bytes), formatSkipReasons(folder.SkipReasons))
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nWould upload %d messages (%s)", report.Messages, formatBytes(report.Bytes))
+	if report.Skipped > 0 {
+		fmt.Fprintf(out, ", %d skipped", report.Skipped)
+	}
+	if report.Filtered > 0 {
+		fmt.Fprintf(out, ", %d filtered", report.Filtered)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Would sync %d contacts\n", report.Contacts)
+}
+
+// writeDryRunReport writes the report as JSON or CSV, chosen by file extension
+func writeDryRunReport(path string, report *DryRunReport) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var err error
+		if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	case ".csv":
+		var buf strings.Builder
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"folder", "mailbox", "messages", "bytes", "skip_reason", "skipped"})
+		for _, folder := range report.Folders {
+			w.Write([]string{
+				folder.Folder,
+				folder.Mailbox,
+				strconv.Itoa(folder.Messages),
+				strconv.FormatInt(folder.Bytes, 10),
+				folder.SkipReason,
+				formatSkipReasons(folder.SkipReasons),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		data = []byte(buf.String())
+	default:
+		return fmt.Errorf("unsupported report format %q (use .json or .csv)", filepath.Ext(path))
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// formatSkipReasons formats skipped item counts as "reason: n; reason: n"
+func formatSkipReasons(reasons map[string]int) string {
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, reason := range names {
+		parts[i] = fmt.Sprintf("%s: %d", reason, reasons[reason])
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// Export writes the mail in a PST to local files, either one .eml file per
+// message or one mbox file per folder
+// Messages that can't be written are reported and counted in the error
+func Export(opts Options) error {
+	if opts.ExportFormat != "eml" && opts.ExportFormat != "mbox" {
+		return fmt.Errorf("unknown export format %q (use eml or mbox)", opts.ExportFormat)
+	}
+
+	if err := os.MkdirAll(opts.ExportDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+
+	var (
+		folderCount   = make(map[string]int) // Messages written per output file or directory
+		mboxOpened    = make(map[string]bool)
+		mbox          *os.File
+		mboxWriter    *bufio.Writer
+		totalCount    int
+		totalErrors   int
+		totalFiltered int
+	)
+
+	closeMbox := func() {
+		if mbox != nil {
+			mboxWriter.Flush()
+			mbox.Close()
+			mbox = nil
+		}
+	}
+	defer closeMbox()
+
+	extractor.SetSkipCallback(func(folderName, reason string, count int) {
+		if strings.HasPrefix(reason, pst.SkipFiltered) {
+			totalFiltered += count
+		}
+	})
+
+	err = extractor.Process(
+		context.Background(),
+		func(info *pst.FolderInfo) (bool, error) {
+			folderName := info.Path
+			if reason := opts.Folders.SkipReason(info); reason != "" {
+				fmt.Printf("[%s] skipping (%s)\n", folderName, reason)
+				return true, nil
+			}
+			fmt.Printf("[%s]\n", folderName)
+
+			if opts.ExportFormat == "mbox" {
+				closeMbox()
+				// Truncate what an earlier export left, then append so
+				// folders sharing a name end up in one mailbox
+				path := filepath.Join(opts.ExportDir, exportFileName(folderName)+".mbox")
+				flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+				if !mboxOpened[path] {
+					flags |= os.O_TRUNC
+					mboxOpened[path] = true
+				}
+				f, err := os.OpenFile(path, flags, 0644)
+				if err != nil {
+					return false, fmt.Errorf("failed to create %s: %w", path, err)
+				}
+				mbox = f
+				mboxWriter = bufio.NewWriter(f)
+			}
+			return false, nil
+		},
+		func(folderName string, msg *pst.Message) error {
+			var err error
+			if opts.ExportFormat == "mbox" {
+				err = writeMboxMessage(mboxWriter, msg)
+			} else {
+				dir := filepath.Join(opts.ExportDir, exportFileName(folderName))
+				if folderCount[dir] == 0 {
+					err = os.MkdirAll(dir, 0755)
+				}
+				if err == nil {
+					path := filepath.Join(dir, fmt.Sprintf("%06d.eml", folderCount[dir]+1))
+					err = os.WriteFile(path, msg.Content, 0644)
+				}
+				folderCount[dir]++
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write message %s: %v\n", msg.ID, err)
+				totalErrors++
+				return nil
+			}
+			totalCount++
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("error during export: %w", err)
+	}
+
+	fmt.Printf("\nExported %d messages to %s", totalCount, opts.ExportDir)
+	if totalFiltered > 0 {
+		fmt.Printf(", %d filtered", totalFiltered)
+	}
+	if totalErrors > 0 {
+		fmt.Printf(" (%d errors)", totalErrors)
+	}
+	fmt.Println()
+
+	if totalErrors > 0 {
+		return fmt.Errorf("%d messages could not be written", totalErrors)
+	}
+	return nil
+}
+
+// writeMboxMessage appends a message in mboxrd format: a "From " separator
+// line, the message with LF line endings and quoted "From " lines, and a
+// trailing blank line
+func writeMboxMessage(w *bufio.Writer, msg *pst.Message) error {
+	fmt.Fprintf(w, "From MAILER-DAEMON %s\n", msg.Date.UTC().Format("Mon Jan _2 15:04:05 2006"))
+
+	content := bytes.ReplaceAll(msg.Content, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			w.WriteByte('>')
+		}
+		w.Write(line)
+	}
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		w.WriteByte('\n')
+	}
+	w.WriteByte('\n')
+
+	return w.Flush()
+}
+
+// exportFileName makes a folder name safe to use as a file or directory name
+func exportFileName(folderName string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return '_'
+		}
+		return r
+	}, folderName)
+
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
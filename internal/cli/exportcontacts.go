@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// ExportContacts writes the contacts in a PST to vCard files, either all of
+// them in one .vcf file or, with ExportSplit, one file per contact
+// With ExportSplit, contacts from the main Contacts folder go in the output
+// directory and those from other contacts folders in a directory each.
+func ExportContacts(opts Options) error {
+	dir := opts.ExportDir
+	if !opts.ExportSplit {
+		dir = filepath.Dir(opts.ExportDir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+
+	var (
+		file        *os.File
+		fileWriter  *bufio.Writer
+		seen        = make(map[string]bool) // UIDs written
+		names       = make(map[string]int)  // Files written per path, without the extension
+		totalCount  int
+		totalErrors int
+	)
+
+	if !opts.ExportSplit {
+		f, err := os.Create(opts.ExportDir)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", opts.ExportDir, err)
+		}
+		file = f
+		fileWriter = bufio.NewWriter(f)
+	}
+
+	err = extractor.ProcessContacts(
+		context.Background(),
+		func(contact *pst.Contact) error {
+			// The same contact in two folders would be two cards with one UID
+			if seen[contact.UID] {
+				return nil
+			}
+			seen[contact.UID] = true
+
+			var err error
+			if opts.ExportSplit {
+				err = writeContactFile(opts.ExportDir, contact, names)
+			} else {
+				err = pst.EncodeCard(fileWriter, contact.Card)
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write contact %s: %v\n", contact.Name, err)
+				totalErrors++
+				return nil
+			}
+			totalCount++
+			return nil
+		},
+		nil,
+	)
+	if file != nil {
+		if flushErr := fileWriter.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error during export: %w", err)
+	}
+
+	fmt.Printf("Exported %d contacts to %s", totalCount, opts.ExportDir)
+	if totalErrors > 0 {
+		fmt.Printf(" (%d errors)", totalErrors)
+	}
+	fmt.Println()
+
+	if totalErrors > 0 {
+		return fmt.Errorf("%d contacts could not be written", totalErrors)
+	}
+	return nil
+}
+
+// writeContactFile writes a contact to its own .vcf file, named after the
+// contact, below dir
+// names counts the files written per name so contacts sharing one get
+// "Name (2).vcf" and so on.
+func writeContactFile(dir string, contact *pst.Contact, names map[string]int) error {
+	if !contact.DefaultFolder {
+		dir = filepath.Join(dir, exportFileName(contact.Folder))
+	}
+	base := filepath.Join(dir, exportFileName(contact.Name))
+	names[base]++
+	if names[base] == 1 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	} else {
+		base = fmt.Sprintf("%s (%d)", base, names[base])
+	}
+
+	f, err := os.Create(base + ".vcf")
+	if err != nil {
+		return err
+	}
+	if err := pst.EncodeCard(f, contact.Card); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// stringList is a flag that collects every value it is given
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// dateValue is a flag holding a date: YYYY-MM-DD, RFC 3339, or an age before
+// now such as 90d, 6m or 7y
+type dateValue struct {
+	t *time.Time
+}
+
+func (v dateValue) String() string {
+	if v.t == nil || v.t.IsZero() {
+		return ""
+	}
+	return v.t.Format(time.RFC3339)
+}
+
+func (v dateValue) Set(value string) error {
+	t, err := pst.ParseDate(value, time.Now())
+	if err != nil {
+		return err
+	}
+	*v.t = t
+	return nil
+}
+
+// sizeValue is a flag holding a byte count with an optional KB, MB or GB suffix
+type sizeValue struct {
+	n *int64
+}
+
+func (v sizeValue) String() string {
+	if v.n == nil || *v.n == 0 {
+		return ""
+	}
+	return formatBytes(*v.n)
+}
+
+func (v sizeValue) Set(value string) error {
+	n, err := pst.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*v.n = n
+	return nil
+}
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// List prints the PST folder tree with item counts
+func List(opts Options) error {
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+
+	folders, err := extractor.Folders()
+	if err != nil {
+		return fmt.Errorf("failed to read folders: %w", err)
+	}
+
+	if opts.ListMapping {
+		listMapping(opts, folders)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	total := 0
+	for _, folder := range folders {
+		if opts.ListPaths {
+			fmt.Fprintf(w, "%s\t%s\t%d\t\n", folder.Path, folderTypeLabel(folder.Type), folder.MessageCount)
+		} else {
+			fmt.Fprintf(w, "%s%s\t%s\t%d\t\n", strings.Repeat("  ", folder.Depth), folder.Name, folderTypeLabel(folder.Type), folder.MessageCount)
+		}
+		total += folder.MessageCount
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d folders, %d items\n", len(folders), total)
+	return nil
+}
+
+// folderTypeLabel shows the type of special folders, and nothing for others
+func folderTypeLabel(folderType pst.FolderType) string {
+	if folderType == pst.FolderMail {
+		return ""
+	}
+	return "[" + string(folderType) + "]"
+}
+
+// listMapping prints each folder path with the IMAP folder it is uploaded to,
+// marking IMAP folders that several PST folders are merged into
+func listMapping(opts Options, folders []pst.FolderInfo) {
+	opts.FolderMap.SetFolderTypes(folders)
+	mailboxes := make([]string, len(folders))
+	sources := make(map[string]int)
+	for i, folder := range folders {
+		mailboxes[i] = opts.FolderMap.Map(folder.Path)
+		sources[strings.ToLower(mailboxes[i])]++
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PST FOLDER\tIMAP FOLDER\tITEMS")
+	merged := 0
+	for i, folder := range folders {
+		mailbox := mailboxes[i]
+		if sources[strings.ToLower(mailbox)] > 1 {
+			mailbox += " (merged)"
+			merged++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", folder.Path, mailbox, folder.MessageCount)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d folders", len(folders))
+	if merged > 0 {
+		fmt.Printf(", %d merged into shared IMAP folders", merged)
+	}
+	fmt.Println()
+}
+
+// classStats accumulates item counts and sizes
+type classStats struct {
+	count int
+	size  int64
+}
+
+// Stats prints item counts, sizes, date ranges and item classes for the PST
+func Stats(opts Options) error {
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+
+	var (
+		total            classStats
+		earliest         time.Time
+		latest           time.Time
+		classes          = make(map[string]*classStats)
+		folders          = make(map[string]*classStats)
+		folderOrder      []string
+		currentFolder    string
+		unknownDateItems int
+	)
+
+	err = extractor.Scan(func(folder *pst.FolderInfo, item *pst.ItemInfo) error {
+		if folder.Path != currentFolder {
+			currentFolder = folder.Path
+			folderOrder = append(folderOrder, folder.Path)
+			folders[folder.Path] = &classStats{}
+		}
+
+		class := item.Class
+		if class == "" {
+			class = "(none)"
+		}
+		if classes[class] == nil {
+			classes[class] = &classStats{}
+		}
+
+		for _, s := range []*classStats{&total, classes[class], folders[folder.Path]} {
+			s.count++
+			s.size += item.Size
+		}
+
+		if item.Date.IsZero() {
+			unknownDateItems++
+		} else {
+			if earliest.IsZero() || item.Date.Before(earliest) {
+				earliest = item.Date
+			}
+			if item.Date.After(latest) {
+				latest = item.Date
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan PST: %w", err)
+	}
+
+	fmt.Printf("Items:       %d\n", total.count)
+	fmt.Printf("Total size:  %s\n", formatBytes(total.size))
+	if !earliest.IsZero() {
+		fmt.Printf("Date range:  %s to %s\n", earliest.Format("2006-01-02"), latest.Format("2006-01-02"))
+	}
+	if unknownDateItems > 0 {
+		fmt.Printf("Undated:     %d\n", unknownDateItems)
+	}
+
+	// Item classes, most common first
+	classNames := make([]string, 0, len(classes))
+	for class := range classes {
+		classNames = append(classNames, class)
+	}
+	sort.Slice(classNames, func(i, j int) bool {
+		return classes[classNames[i]].count > classes[classNames[j]].count
+	})
+
+	fmt.Println("\nItem classes:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, class := range classNames {
+		fmt.Fprintf(w, "  %s\t%d\t%s\n", class, classes[class].count, formatBytes(classes[class].size))
+	}
+	w.Flush()
+
+	fmt.Println("\nFolders:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, path := range folderOrder {
+		fmt.Fprintf(w, "  %s\t%d\t%s\n", path, folders[path].count, formatBytes(folders[path].size))
+	}
+	w.Flush()
+	return nil
+}
+
+// formatBytes formats a byte count using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
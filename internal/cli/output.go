@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mxguardian/pst-import-tool/internal/carddav"
+	"github.com/mxguardian/pst-import-tool/internal/imap"
+	"github.com/mxguardian/pst-import-tool/internal/progress"
+)
+
+// Process exit codes, so scripts can tell what happened
+const (
+	ExitSuccess    = 0 // Everything was imported
+	ExitFailure    = 1 // The import could not run or stopped on an error
+	ExitUsage      = 2 // Invalid command line
+	ExitAuth       = 3 // The server rejected the credentials
+	ExitConnection = 4 // The server could not be reached
+	ExitPartial    = 5 // The import finished but some items failed
+
+	ExitInterrupted = 130 // Stopped by SIGINT or SIGTERM (128 + SIGINT)
+)
+
+// ErrPartial is returned when an import finishes with failed items
+var ErrPartial = errors.New("some items failed to import")
+
+// ExitCode maps an error returned by Run to a process exit code
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitSuccess
+	case errors.Is(err, imap.ErrAuth), errors.Is(err, carddav.ErrAuth):
+		return ExitAuth
+	case errors.Is(err, imap.ErrConnection), errors.Is(err, carddav.ErrConnection):
+		return ExitConnection
+	case errors.Is(err, ErrPartial):
+		return ExitPartial
+	case errors.Is(err, ErrInterrupted):
+		return ExitInterrupted
+	default:
+		return ExitFailure
+	}
+}
+
+// Result summarizes a finished import
+type Result struct {
+	Uploaded         int    `json:"uploaded"`
+	Skipped          int    `json:"skipped"`
+	Filtered         int    `json:"filtered"` // Left out by the message filter
+	Failed           int    `json:"failed"`
+	ContactsUploaded int    `json:"contacts_uploaded"`
+	ContactsMerged   int    `json:"contacts_merged"`  // Of those uploaded, merged into existing contacts
+	ContactsSkipped  int    `json:"contacts_skipped"` // Changed on the server and left alone
+	ContactsFailed   int    `json:"contacts_failed"`
+	StatePath        string `json:"state_path,omitempty"` // Set when state is kept for a retry
+}
+
+// Message and contact result statuses
+const (
+	statusUploaded = "uploaded"
+	statusSkipped  = "skipped"
+	statusFailed   = "failed"
+)
+
+// reporter presents import progress, as text for people or as JSON events for scripts
+type reporter interface {
+	Start(pstFile, username, destination string)
+	Info(format string, args ...any) // Free-form progress text, shown in text mode only
+	Warning(err error)
+	Resuming(uploaded, total int, statePath string)
+	FolderStart(folder, mailbox string)
+	FolderSkipped(folder, reason string)
+	FolderEnd(folder string, uploaded, skipped, failed int)
+	Message(folder, id, status string, err error)
+	Contact(name, uid, status string, err error)
+	Progress(snapshot progress.Snapshot) // Called about once a second during the mail import
+	Summary(result *Result, err error)
+}
+
+// newReporter returns the reporter for an --output format writing to out
+// Text output sends warnings and errors to errOut
+func newReporter(format string, out, errOut io.Writer) (reporter, error) {
+	switch format {
+	case "", "text":
+		return &textReporter{out: out, errOut: errOut}, nil
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(out)}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (use text or json)", format)
+}
+
+// textProgressInterval is how often text output prints a progress line
+const textProgressInterval = 30 * time.Second
+
+// textReporter prints the human-readable progress output
+type textReporter struct {
+	out      io.Writer
+	errOut   io.Writer
+	uploaded int
+	contacts int
+
+	progress        progress.Snapshot // Latest progress, shown with folder summaries
+	hasProgress     bool
+	lastProgressOut time.Time
+}
+
+func (r *textReporter) Start(pstFile, username, destination string) {
+	fmt.Fprintln(r.out, "MXGuardian PST Import")
+	fmt.Fprintln(r.out, "=====================")
+}
+
+func (r *textReporter) Info(format string, args ...any) {
+	fmt.Fprintf(r.out, format+"\n", args...)
+}
+
+func (r *textReporter) Warning(err error) {
+	fmt.Fprintf(r.errOut, "Warning: %v\n", err)
+}
+
+func (r *textReporter) Resuming(uploaded, total int, statePath string) {
+	fmt.Fprintf(r.out, "Resuming: %d/%d messages already uploaded\n", uploaded, total)
+	fmt.Fprintf(r.out, "State file: %s\n", statePath)
+	fmt.Fprintln(r.out, "(Use -fresh to start over)")
+}
+
+func (r *textReporter) FolderStart(folder, mailbox string) {
+	fmt.Fprintf(r.out, "[%s] ", folder)
+}
+
+func (r *textReporter) FolderSkipped(folder, reason string) {
+	fmt.Fprintf(r.out, "[%s] skipping (%s)\n", folder, reason)
+}
+
+func (r *textReporter) FolderEnd(folder string, uploaded, skipped, failed int) {
+	switch {
+	case uploaded == 0 && skipped == 0 && failed == 0:
+		fmt.Fprintln(r.out)
+		return
+	case skipped > 0:
+		fmt.Fprintf(r.out, "  → %d uploaded, %d skipped", uploaded, skipped)
+	default:
+		fmt.Fprintf(r.out, "  → %d uploaded", uploaded)
+	}
+	if r.hasProgress {
+		fmt.Fprintf(r.out, " [%s]", r.progress)
+	}
+	fmt.Fprintln(r.out)
+}
+
+func (r *textReporter) Message(folder, id, status string, err error) {
+	switch status {
+	case statusFailed:
+		fmt.Fprint(r.out, "E")
+	case statusUploaded:
+		r.uploaded++
+		if r.uploaded%50 == 0 {
+			fmt.Fprint(r.out, ".")
+		}
+	}
+}
+
+func (r *textReporter) Contact(name, uid, status string, err error) {
+	if status == statusUploaded {
+		r.contacts++
+		if r.contacts%10 == 0 {
+			fmt.Fprint(r.out, ".")
+		}
+	}
+}
+
+func (r *textReporter) Progress(snapshot progress.Snapshot) {
+	r.progress = snapshot
+	r.hasProgress = true
+
+	if r.lastProgressOut.IsZero() {
+		r.lastProgressOut = time.Now()
+		return
+	}
+	if time.Since(r.lastProgressOut) >= textProgressInterval {
+		r.lastProgressOut = time.Now()
+		fmt.Fprintf(r.out, "\n  %s\n", snapshot)
+	}
+}
+
+func (r *textReporter) Summary(result *Result, err error) {
+	if result == nil {
+		fmt.Fprintf(r.errOut, "Import failed: %v\n", err)
+		return
+	}
+
+	fmt.Fprintln(r.out, "\n=====================")
+	fmt.Fprintf(r.out, "Complete: %d uploaded", result.Uploaded)
+	if result.Skipped > 0 {
+		fmt.Fprintf(r.out, ", %d skipped", result.Skipped)
+	}
+	if result.Filtered > 0 {
+		fmt.Fprintf(r.out, ", %d filtered", result.Filtered)
+	}
+	if result.Failed > 0 {
+		fmt.Fprintf(r.out, ", %d errors", result.Failed)
+	}
+	fmt.Fprintln(r.out)
+
+	if result.ContactsUploaded > 0 || result.ContactsSkipped > 0 || result.ContactsFailed > 0 {
+		fmt.Fprintf(r.out, "Contacts: %d uploaded", result.ContactsUploaded)
+		if result.ContactsMerged > 0 {
+			fmt.Fprintf(r.out, " (%d merged into existing contacts)", result.ContactsMerged)
+		}
+		if result.ContactsSkipped > 0 {
+			fmt.Fprintf(r.out, ", %d changed on server and skipped", result.ContactsSkipped)
+		}
+		if result.ContactsFailed > 0 {
+			fmt.Fprintf(r.out, ", %d errors", result.ContactsFailed)
+		}
+		fmt.Fprintln(r.out)
+	}
+
+	if result.StatePath != "" {
+		fmt.Fprintf(r.out, "State saved to: %s\n", result.StatePath)
+		if errors.Is(err, ErrInterrupted) {
+			fmt.Fprintln(r.out, "Interrupted: run again to resume")
+			return
+		}
+		fmt.Fprintln(r.out, "Run again to retry")
+	} else if err == nil {
+		fmt.Fprintln(r.out, "State cleaned up")
+	}
+	if err != nil && !errors.Is(err, ErrPartial) {
+		fmt.Fprintf(r.errOut, "Error during import: %v\n", err)
+	}
+}
+
+// jsonReporter writes one JSON object per line for each event
+type jsonReporter struct {
+	mu  sync.Mutex // Events may come from the signal handler
+	enc *json.Encoder
+}
+
+// event is the common shape of a JSON output line
+type event struct {
+	Event       string  `json:"event"`
+	Time        string  `json:"time"`
+	PSTFile     string  `json:"pst_file,omitempty"`
+	Username    string  `json:"username,omitempty"`
+	Destination string  `json:"destination,omitempty"`
+	Folder      string  `json:"folder,omitempty"`
+	Mailbox     string  `json:"mailbox,omitempty"`
+	ID          string  `json:"id,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Status      string  `json:"status,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	Uploaded    *int    `json:"uploaded,omitempty"`
+	Skipped     *int    `json:"skipped,omitempty"`
+	Failed      *int    `json:"failed,omitempty"`
+	Total       *int    `json:"total,omitempty"`
+	StatePath   string  `json:"state_path,omitempty"`
+	Result      *Result `json:"result,omitempty"`
+	ExitCode    *int    `json:"exit_code,omitempty"`
+}
+
+func (r *jsonReporter) emit(e event) {
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	r.encode(e)
+}
+
+func (r *jsonReporter) encode(v any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(v)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (r *jsonReporter) Start(pstFile, username, destination string) {
+	r.emit(event{Event: "start", PSTFile: pstFile, Username: username, Destination: destination})
+}
+
+func (r *jsonReporter) Info(format string, args ...any) {}
+
+func (r *jsonReporter) Warning(err error) {
+	r.emit(event{Event: "warning", Error: err.Error()})
+}
+
+func (r *jsonReporter) Resuming(uploaded, total int, statePath string) {
+	r.emit(event{Event: "resume", Uploaded: &uploaded, Total: &total, StatePath: statePath})
+}
+
+func (r *jsonReporter) FolderStart(folder, mailbox string) {
+	r.emit(event{Event: "folder_start", Folder: folder, Mailbox: mailbox})
+}
+
+func (r *jsonReporter) FolderSkipped(folder, reason string) {
+	r.emit(event{Event: "folder_skipped", Folder: folder, Reason: reason})
+}
+
+func (r *jsonReporter) FolderEnd(folder string, uploaded, skipped, failed int) {
+	r.emit(event{Event: "folder_end", Folder: folder, Uploaded: &uploaded, Skipped: &skipped, Failed: &failed})
+}
+
+func (r *jsonReporter) Message(folder, id, status string, err error) {
+	r.emit(event{Event: "message", Folder: folder, ID: id, Status: status, Error: errorString(err)})
+}
+
+func (r *jsonReporter) Contact(name, uid, status string, err error) {
+	r.emit(event{Event: "contact", Name: name, ID: uid, Status: status, Error: errorString(err)})
+}
+
+func (r *jsonReporter) Progress(snapshot progress.Snapshot) {
+	r.encode(struct {
+		Event          string  `json:"event"`
+		Time           string  `json:"time"`
+		Percent        float64 `json:"percent"`
+		Messages       int     `json:"messages"`
+		TotalMessages  int     `json:"total_messages"`
+		Bytes          int64   `json:"bytes"`
+		TotalBytes     int64   `json:"total_bytes"`
+		MessagesPerSec float64 `json:"messages_per_sec"`
+		BytesPerSec    float64 `json:"bytes_per_sec"`
+		ETASeconds     int64   `json:"eta_seconds,omitempty"`
+	}{
+		Event:          "progress",
+		Time:           time.Now().UTC().Format(time.RFC3339),
+		Percent:        snapshot.Percent,
+		Messages:       snapshot.Messages,
+		TotalMessages:  snapshot.TotalMessages,
+		Bytes:          snapshot.Bytes,
+		TotalBytes:     snapshot.TotalBytes,
+		MessagesPerSec: snapshot.MessagesPerSec,
+		BytesPerSec:    snapshot.BytesPerSec,
+		ETASeconds:     int64(snapshot.ETA.Seconds()),
+	})
+}
+
+func (r *jsonReporter) Summary(result *Result, err error) {
+	code := ExitCode(err)
+	r.emit(event{Event: "summary", Result: result, Error: errorString(err), ExitCode: &code})
+}
@@ -1,265 +1,583 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/mxguardian/pst-import-tool/internal/carddav"
 	"github.com/mxguardian/pst-import-tool/internal/imap"
+	"github.com/mxguardian/pst-import-tool/internal/progress"
 	"github.com/mxguardian/pst-import-tool/internal/pst"
 	"github.com/mxguardian/pst-import-tool/internal/state"
 )
 
 // Options holds the CLI configuration options
 type Options struct {
-	PSTFile     string
-	Username    string
-	Password    string
-	Fresh       bool
-	SkipDeleted bool
-	SkipSent    bool
+	PSTFile  string
+	Username string
+	Password string
+	Fresh    bool
+	StateDir string // Directory for resume state; empty uses the default
+
+	// Folders selects the folders to import, by full path
+	Folders pst.FolderRules
+
+	// Filter selects the messages to import within those folders
+	Filter pst.MessageFilter
+
+	// StateEncryption selects how state is encrypted at rest: "none" (or empty),
+	// "passphrase" (read from $PST_IMPORT_STATE_PASSPHRASE) or "keyring"
+	StateEncryption string
+
+	// FolderMap chooses the IMAP folder for each PST folder; FolderMapFile is
+	// read into it by parseFlags, with --folder-root and --flatten on top
+	FolderMap     imap.FolderMap
+	FolderMapFile string
+
+	// Servers to upload to; zero values select the MXGuardian servers
+	Server       imap.Server
+	CardDAV      carddav.Server
+	AddressBooks string // carddav.AddressBooksMerged (default) or AddressBooksPerFolder
+
+	// ContactConflict is what happens to contacts changed on the server:
+	// carddav.ConflictSkip (default), ConflictOverwrite or ConflictMerge
+	ContactConflict string
+
+	// MatchContacts merges contacts into existing server contacts with the
+	// same email address or phone number; ContactsReport lists the outcome
+	// for each contact in a .json or .csv file
+	MatchContacts  bool
+	ContactsReport string
+
+	// SkipPhotos leaves contact pictures out of the vCards; ContactGroups is
+	// how distribution lists are written: pst.GroupsKind (default) or
+	// GroupsApple; VCardVersion is pst.VCard30 (default) or VCard40
+	SkipPhotos    bool
+	ContactGroups string
+	VCardVersion  string
+
+	// Config file and profile supplying defaults for unset flags
+	ConfigFile string
+	Profile    string
+
+	// DryRun reports what would be uploaded without connecting or saving state
+	DryRun     bool
+	ReportFile string // Dry-run report destination; .json or .csv
+
+	// Output selects "text" (default) or "json" progress output
+	Output string
+
+	// Batch settings
+	Manifest string // CSV or JSON list of imports
+	Parallel int    // Imports run at once
+	LogDir   string // Per-job logs; empty uses <manifest>-logs
+
+	// ListPaths makes list print full folder paths instead of a tree, and
+	// ListMapping adds the IMAP folder each one is uploaded to
+	ListPaths   bool
+	ListMapping bool
+
+	// Export settings
+	ExportDir    string // For export-contacts, a .vcf file unless ExportSplit is set
+	ExportFormat string // "eml" or "mbox"
+	ExportSplit  bool   // One .vcf file per contact
 }
 
-// Run executes the CLI import process
-func Run(opts Options) {
+// progressInterval is the minimum time between progress reports
+const progressInterval = time.Second
+
+// statePassphraseEnv holds the passphrase for --state-encryption=passphrase
+const statePassphraseEnv = state.PassphraseEnv
+
+// Run executes the CLI import process and returns what was imported
+// The error is ErrPartial if the import finished with failed items; see ExitCode
+// With DryRun set it only reports what would be uploaded
+func Run(opts Options) (*Result, error) {
+	if opts.DryRun {
+		return nil, DryRun(opts)
+	}
+
+	rep, err := newReporter(opts.Output, os.Stdout, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ctrl-C stops after the current upload and saves progress
+	interrupts := handleInterrupts(rep)
+	defer interrupts.stop()
+
+	var result *Result
+	store, err := openStore(opts)
+	if err != nil {
+		err = fmt.Errorf("failed to open state directory: %w", err)
+	} else {
+		result, err = importPST(opts, rep, store, interrupts)
+	}
+	rep.Summary(result, err)
+	return result, err
+}
+
+// importPST does the work of Run, reporting progress to rep and keeping
+// progress in store
+func importPST(opts Options, rep reporter, store *state.Store, interrupts *interruptHandler) (*Result, error) {
 	pstFile := opts.PSTFile
 	username := opts.Username
 	password := opts.Password
 	fresh := opts.Fresh
-	fmt.Println("MXGuardian PST Import")
-	fmt.Println("=====================")
+	rep.Start(pstFile, username, opts.Server.Destination())
 
 	// Initialize state management
-	importState, err := state.NewImportState(pstFile, username)
+	importState, err := store.NewImportState(pstFile, username, opts.Server.Destination())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to initialize state: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to initialize state: %w", err)
 	}
 
 	if !fresh {
+		// Starting over instead would upload everything again and overwrite the state
 		if err := importState.Load(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to load state: %v\n", err)
+			return nil, fmt.Errorf("failed to load state (use --fresh to start over): %w", err)
 		}
-	} else {
-		importState.Clear()
+	} else if err := importState.Clear(); err != nil {
+		// The old state would be read again by the next run without --fresh
+		return nil, fmt.Errorf("failed to clear state: %w", err)
 	}
 
-	if importState.HasExistingProgress() {
-		uploaded, total := importState.GetProgress()
-		fmt.Printf("Resuming: %d/%d messages already uploaded\n", uploaded, total)
-		fmt.Printf("State file: %s\n", importState.StatePath())
-		fmt.Println("(Use -fresh to start over)")
+	// Open PST file
+	rep.Info("\nOpening PST file: %s", pstFile)
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return nil, err
 	}
-
-	// Test IMAP connection
-	fmt.Println("\nConnecting to IMAP server...")
-	if err := imap.TestConnection(username, password); err != nil {
-		fmt.Fprintf(os.Stderr, "IMAP connection failed: %v\n", err)
-		os.Exit(1)
+	defer extractor.Close()
+	if err := setFolderTypes(&opts, extractor); err != nil {
+		return nil, err
 	}
-	fmt.Println("Connected successfully")
 
-	// Open PST file
-	fmt.Printf("\nOpening PST file: %s\n", pstFile)
-	extractor, err := pst.NewExtractor()
+	// Count what there is to do, so progress can show percent and ETA
+	tracker, err := prescan(opts, extractor, importState)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to initialize: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
-	defer extractor.Close()
 
-	if err := extractor.Open(pstFile); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open PST: %v\n", err)
-		os.Exit(1)
+	if importState.HasExistingProgress() {
+		uploaded, total := importState.GetProgress()
+		rep.Resuming(uploaded, total, importState.StatePath())
+	}
+
+	// A forced exit saves progress first
+	defer interrupts.onForce(func() {
+		if err := importState.Save(); err != nil {
+			rep.Warning(fmt.Errorf("failed to save state: %w", err))
+		}
+	})()
+
+	// Test IMAP connection
+	rep.Info("\nConnecting to IMAP server...")
+	if err := imap.TestConnection(interrupts.Soft, opts.Server, username, password); err != nil {
+		return nil, interruptedOr(interrupts, err)
 	}
+	rep.Info("Connected successfully")
 
 	// Connect to IMAP for uploading
-	uploader, err := imap.NewUploader(username, password)
+	uploader, err := imap.NewUploader(interrupts.Soft, opts.Server, username, password)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
-		os.Exit(1)
+		return nil, interruptedOr(interrupts, err)
 	}
 	defer uploader.Close()
+	uploader.SetFolderMap(&opts.FolderMap)
 
 	// Stream messages
-	fmt.Println("\nStreaming messages...")
+	rep.Info("\nStreaming messages...")
 
 	var (
+		result          = &Result{}
 		currentFolder   string
 		folderUploaded  int
 		folderSkipped   int
-		totalUploaded   int
-		totalSkipped    int
-		totalErrors     int
+		folderFailed    int
 		saveCounter     int
 		completedFolder = make(map[string]bool)
+		lastSkipped     bool // Whether the last message was already uploaded or filtered
+		lastProgress    time.Time
 	)
 
+	// endFolder reports the current folder; it is complete unless stopped part way
+	endFolder := func(stopped bool) {
+		if currentFolder == "" {
+			return
+		}
+		rep.FolderEnd(currentFolder, folderUploaded, folderSkipped, folderFailed)
+		if folderFailed == 0 && !stopped {
+			completedFolder[currentFolder] = true
+		}
+		currentFolder = ""
+	}
+
+	extractor.SetSkipCallback(func(folderName, reason string, count int) {
+		if strings.HasPrefix(reason, pst.SkipFiltered) {
+			lastSkipped = true
+			result.Filtered += count
+		}
+	})
+
 	err = extractor.Process(
+		interrupts.Soft,
 		// On folder start
-		func(folderName string) (skip bool, err error) {
-			// Print previous folder summary
-			if currentFolder != "" && (folderUploaded > 0 || folderSkipped > 0) {
-				if folderSkipped > 0 {
-					fmt.Printf("  → %d uploaded, %d skipped\n", folderUploaded, folderSkipped)
-				} else {
-					fmt.Printf("  → %d uploaded\n", folderUploaded)
-				}
-				completedFolder[currentFolder] = true
-			}
-
-			currentFolder = folderName
-			folderUploaded = 0
-			folderSkipped = 0
+		func(info *pst.FolderInfo) (skip bool, err error) {
+			folderName := info.Path
+			endFolder(false)
 
 			// Check if folder should be skipped based on options
-			lowerFolder := strings.ToLower(folderName)
-			if opts.SkipDeleted && (strings.Contains(lowerFolder, "deleted items") || strings.Contains(lowerFolder, "trash")) {
-				fmt.Printf("[%s] skipping (--skip-deleted)\n", folderName)
-				return true, nil
-			}
-			if opts.SkipSent && (strings.Contains(lowerFolder, "sent items") || lowerFolder == "sent") {
-				fmt.Printf("[%s] skipping (--skip-sent)\n", folderName)
+			if reason := opts.Folders.SkipReason(info); reason != "" {
+				rep.FolderSkipped(folderName, reason)
 				return true, nil
 			}
 
 			// Check if folder already complete
 			if importState.IsFolderComplete(folderName) {
-				fmt.Printf("[%s] skipping (already complete)\n", folderName)
+				rep.FolderSkipped(folderName, "already complete")
 				return true, nil
 			}
 
-			fmt.Printf("[%s] ", folderName)
+			currentFolder = folderName
+			folderUploaded = 0
+			folderSkipped = 0
+			folderFailed = 0
+			rep.FolderStart(folderName, opts.FolderMap.Map(folderName))
 			return false, nil
 		},
 		// On each message
 		func(folderName string, msg *pst.Message) error {
 			// Check if already uploaded
+			lastSkipped = false
 			if importState.IsUploaded(msg.ID) {
+				lastSkipped = true
 				folderSkipped++
-				totalSkipped++
+				result.Skipped++
+				rep.Message(folderName, msg.ID, statusSkipped, nil)
 				return nil
 			}
 
 			// Upload immediately
-			if err := uploader.Upload(folderName, msg); err != nil {
-				totalErrors++
-				fmt.Printf("E")
+			if err := uploader.Upload(interrupts.Hard, folderName, msg); err != nil {
+				if interrupts.Hard.Err() != nil {
+					// Abandoned after an interrupt, not a failure of this message
+					return err
+				}
+				importState.MarkFailed(msg.ID, err)
+				folderFailed++
+				result.Failed++
+				rep.Message(folderName, msg.ID, statusFailed, err)
 				return nil
 			}
 
 			// Mark as uploaded
 			importState.MarkUploaded(msg.ID)
 			folderUploaded++
-			totalUploaded++
-
-			// Progress indicator
-			if totalUploaded%50 == 0 {
-				fmt.Printf(".")
-			}
+			result.Uploaded++
+			rep.Message(folderName, msg.ID, statusUploaded, nil)
 
 			// Save state periodically
 			saveCounter++
 			if saveCounter%50 == 0 {
-				importState.Save()
+				if err := importState.Save(); err != nil {
+					rep.Warning(fmt.Errorf("failed to save state: %w", err))
+				}
 			}
 
 			return nil
 		},
-		nil,
+		// After each item, uploaded or not
+		func(folderName string, size int64) {
+			if lastSkipped {
+				tracker.Skip(1, size)
+				lastSkipped = false
+			} else {
+				tracker.Add(1, size)
+			}
+			if time.Since(lastProgress) >= progressInterval {
+				lastProgress = time.Now()
+				rep.Progress(tracker.Snapshot())
+			}
+		},
 	)
 
-	// Print final folder summary
-	if currentFolder != "" && (folderUploaded > 0 || folderSkipped > 0) {
-		if folderSkipped > 0 {
-			fmt.Printf("  → %d uploaded, %d skipped\n", folderUploaded, folderSkipped)
-		} else {
-			fmt.Printf("  → %d uploaded\n", folderUploaded)
-		}
-	}
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "\nError during import: %v\n", err)
-	}
+	// Print final folder summary; a folder the import stopped in, interrupted
+	// or on an error, isn't complete
+	interrupted := interrupts.Stopping()
+	endFolder(interrupted || err != nil)
 
 	// Mark completed folders
 	for folder := range completedFolder {
 		importState.MarkFolderComplete(folder)
 	}
-	importState.Save()
+	// Without the saved state the next run would upload everything again
+	if saveErr := importState.Save(); saveErr != nil {
+		result.StatePath = importState.StatePath()
+		return result, fmt.Errorf("failed to save state: %w", saveErr)
+	}
 
-	// Summary
-	fmt.Println("\n=====================")
-	fmt.Printf("Complete: %d uploaded", totalUploaded)
-	if totalSkipped > 0 {
-		fmt.Printf(", %d skipped", totalSkipped)
+	if interrupted {
+		result.StatePath = importState.StatePath()
+		return result, ErrInterrupted
 	}
-	if totalErrors > 0 {
-		fmt.Printf(", %d errors", totalErrors)
+	if err != nil {
+		result.StatePath = importState.StatePath()
+		return result, fmt.Errorf("error during import: %w", err)
 	}
-	fmt.Println()
 
 	// Sync contacts to CardDAV
-	contactsErrors := syncContacts(extractor, username, password)
+	if err := syncContacts(interrupts.Soft, interrupts.Hard, extractor, opts, store, rep, result); err != nil {
+		result.StatePath = importState.StatePath()
+		return result, interruptedOr(interrupts, err)
+	}
 
 	// Clean up state only if no errors in email or contact sync
-	if totalErrors == 0 && contactsErrors == 0 {
-		importState.Clear()
-		fmt.Println("State cleaned up")
-	} else {
-		fmt.Printf("State saved to: %s\n", importState.StatePath())
-		fmt.Println("Run again to retry")
+	if result.Failed > 0 || result.ContactsFailed > 0 {
+		result.StatePath = importState.StatePath()
+		return result, ErrPartial
+	}
+
+	if err := importState.Finish(); err != nil {
+		result.StatePath = importState.StatePath()
+		return result, fmt.Errorf("failed to mark the import complete: %w", err)
 	}
+	return result, nil
 }
 
-// syncContacts uploads contacts from the PST to CardDAV
-// Returns the number of errors encountered
-func syncContacts(extractor *pst.Extractor, username, password string) int {
-	fmt.Println("\nSyncing contacts...")
+// interruptedOr returns ErrInterrupted if err follows an interrupt, else err
+func interruptedOr(interrupts *interruptHandler, err error) error {
+	if interrupts.Stopping() {
+		return ErrInterrupted
+	}
+	return err
+}
 
-	// Connect to CardDAV
-	cardDAVUploader, err := carddav.NewUploader(username, password)
+// setFolderTypes lets opts.FolderMap find the special folders of the PST
+// by their type
+func setFolderTypes(opts *Options, extractor *pst.Extractor) error {
+	folders, err := extractor.Folders()
 	if err != nil {
-		fmt.Printf("CardDAV connection failed: %v\n", err)
-		return 1
+		return fmt.Errorf("failed to read folders: %w", err)
+	}
+	opts.FolderMap.SetFolderTypes(folders)
+	return nil
+}
+
+// prescan totals the messages and bytes in the folders the import will visit,
+// records the total in the import state and returns a tracker for this run
+// Folders completed by an earlier run count towards the state total but not
+// towards this run's progress
+func prescan(opts Options, extractor *pst.Extractor, importState *state.ImportState) (*progress.Tracker, error) {
+	totals, err := extractor.Prescan()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan PST: %w", err)
 	}
-	defer cardDAVUploader.Close()
 
 	var (
-		contactsUploaded int
-		contactsErrors   int
+		allMessages int
+		messages    int
+		bytes       int64
 	)
+	for _, folder := range totals {
+		if opts.Folders.SkipReason(&folder.FolderInfo) != "" {
+			continue
+		}
+		allMessages += folder.Messages
+		if importState.IsFolderComplete(folder.Path) {
+			continue
+		}
+		messages += folder.Messages
+		bytes += folder.Bytes
+	}
+
+	importState.SetTotal(allMessages)
+	return progress.NewTracker(messages, bytes), nil
+}
+
+// newExtractor opens the PST file with the message filter applied
+func newExtractor(opts Options) (*pst.Extractor, error) {
+	extractor, err := pst.NewExtractor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize: %w", err)
+	}
+
+	if err := extractor.SetFilter(&opts.Filter); err != nil {
+		return nil, err
+	}
+	extractor.SetSkipPhotos(opts.SkipPhotos)
+	if err := extractor.SetGroupStyle(opts.ContactGroups); err != nil {
+		return nil, err
+	}
+	if err := extractor.SetVCardVersion(opts.VCardVersion); err != nil {
+		return nil, err
+	}
+
+	if err := extractor.Open(opts.PSTFile); err != nil {
+		return nil, fmt.Errorf("failed to open PST: %w", err)
+	}
+
+	return extractor, nil
+}
+
+// openStore opens the state directory with the configured encryption
+func openStore(opts Options) (*state.Store, error) {
+	return openStoreKey(opts, true)
+}
+
+// openExistingStore opens the state directory to read it: with keyring
+// encryption and no key in the keyring yet, it fails with state.ErrNoKey
+// rather than creating one
+func openExistingStore(opts Options) (*state.Store, error) {
+	return openStoreKey(opts, false)
+}
+
+// openStoreKey opens the state directory, creating a keyring key if there is
+// none and createKey is set
+func openStoreKey(opts Options, createKey bool) (*state.Store, error) {
+	store, err := state.NewStore(opts.StateDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.UseEncryption(opts.StateEncryption, createKey); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Contacts syncs only the contacts from the PST to CardDAV
+func Contacts(opts Options) error {
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
 
+	rep := &textReporter{out: os.Stdout, errOut: os.Stderr}
+	store, err := openStore(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open state directory: %w", err)
+	}
+	result := &Result{}
+	ctx := context.Background()
+	if err := syncContacts(ctx, ctx, extractor, opts, store, rep, result); err != nil {
+		return err
+	}
+	if result.ContactsFailed > 0 {
+		return ErrPartial
+	}
+	return nil
+}
+
+// syncContacts uploads contacts from the PST to CardDAV, counting them in result
+// The server copy of each contact is remembered in store, if not nil, so a
+// later run can tell which ones were changed on the server
+// Failed uploads are counted rather than returned; rejected credentials stop the sync
+// No new contacts are started once ctx is done, and uploads are abandoned once uploadCtx is
+func syncContacts(ctx, uploadCtx context.Context, extractor *pst.Extractor, opts Options, store *state.Store, rep reporter, result *Result) error {
+	rep.Info("\nSyncing contacts...")
+
+	// Connect to CardDAV
+	cardDAVUploader, err := carddav.NewUploader(uploadCtx, opts.CardDAV, opts.Username, opts.Password)
+	if err != nil {
+		return fmt.Errorf("CardDAV connection failed: %w", err)
+	}
+	rep.Info("Address book: %s", cardDAVUploader.AddressBook())
+	defer cardDAVUploader.Close()
+	if err := cardDAVUploader.SetAddressBooks(opts.AddressBooks); err != nil {
+		return err
+	}
+	if err := cardDAVUploader.SetConflictPolicy(opts.ContactConflict); err != nil {
+		return err
+	}
+	cardDAVUploader.SetMatchExisting(opts.MatchContacts)
+	contactState, err := loadContactState(opts, store, cardDAVUploader.AddressBook(), rep)
+	if err != nil {
+		return err
+	}
+	if contactState != nil {
+		cardDAVUploader.SetETagStore(contactState)
+		defer func() {
+			if err := contactState.Save(); err != nil {
+				rep.Warning(fmt.Errorf("failed to save contact state: %w", err))
+			}
+		}()
+	}
+
+	report := &ContactReport{AddressBook: cardDAVUploader.AddressBook()}
 	err = extractor.ProcessContacts(
+		ctx,
 		func(contact *pst.Contact) error {
-			if err := cardDAVUploader.Upload(contact); err != nil {
-				contactsErrors++
+			outcome, err := cardDAVUploader.Upload(uploadCtx, contact)
+			if uploadCtx.Err() == nil {
+				report.add(contact, outcome, err)
+			}
+			if err != nil {
+				if uploadCtx.Err() != nil {
+					return err
+				}
+				if errors.Is(err, carddav.ErrConflict) {
+					result.ContactsSkipped++
+					rep.Contact(contact.Name, contact.UID, statusSkipped, err)
+					return nil
+				}
+				result.ContactsFailed++
+				rep.Contact(contact.Name, contact.UID, statusFailed, err)
+				if errors.Is(err, carddav.ErrAuth) {
+					return err
+				}
 				return nil
 			}
-			contactsUploaded++
-			if contactsUploaded%10 == 0 {
-				fmt.Printf(".")
+			result.ContactsUploaded++
+			if outcome.Action == carddav.ActionMerged {
+				result.ContactsMerged++
 			}
+			rep.Contact(contact.Name, contact.UID, statusUploaded, nil)
 			return nil
 		},
 		nil,
 	)
-
+	if opts.ContactsReport != "" {
+		if err := writeContactReport(opts.ContactsReport, report); err != nil {
+			rep.Warning(fmt.Errorf("failed to write contacts report: %w", err))
+		} else {
+			rep.Info("Contacts report written to %s", opts.ContactsReport)
+		}
+	}
 	if err != nil {
-		fmt.Printf("\nError syncing contacts: %v\n", err)
-		contactsErrors++
+		return fmt.Errorf("error syncing contacts: %w", err)
 	}
 
-	if contactsUploaded > 0 || contactsErrors > 0 {
-		fmt.Printf("\nContacts: %d uploaded", contactsUploaded)
-		if contactsErrors > 0 {
-			fmt.Printf(", %d errors", contactsErrors)
-		}
-		fmt.Println()
-	} else {
-		fmt.Println("No contacts found")
+	if result.ContactsUploaded == 0 && result.ContactsSkipped == 0 && result.ContactsFailed == 0 {
+		rep.Info("No contacts found")
 	}
 
-	return contactsErrors
+	return nil
+}
+
+// loadContactState loads where earlier runs stored each contact on the server
+// With --fresh, contacts are uploaded as if for the first time, so existing
+// ones are left to the conflict policy. Without a state store (nil) they
+// overwrite whatever the server has.
+// Saved state that can't be read is an error: syncing anyway would lose track
+// of the contacts on the server once the state is saved again.
+func loadContactState(opts Options, store *state.Store, addressBook string, rep reporter) (*state.ContactState, error) {
+	if store == nil {
+		return nil, nil
+	}
+	contactState, err := store.NewContactState(opts.PSTFile, opts.Username, addressBook)
+	if err != nil {
+		rep.Warning(fmt.Errorf("failed to initialize contact state: %w", err))
+		return nil, nil
+	}
+	if !opts.Fresh {
+		if err := contactState.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load contact state (use --fresh to start over): %w", err)
+		}
+	}
+	return contactState, nil
 }
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long an interrupted import waits for the in-flight
+// upload before abandoning it
+const shutdownTimeout = 30 * time.Second
+
+// ErrInterrupted is returned when an import is stopped by SIGINT or SIGTERM
+var ErrInterrupted = errors.New("import interrupted")
+
+// interruptHandler turns SIGINT/SIGTERM into context cancellation in two stages.
+// The first signal cancels Soft, so no new work is started, and cancels Hard
+// after shutdownTimeout, abandoning in-flight uploads. A second signal exits
+// immediately after calling the functions registered with onForce.
+type interruptHandler struct {
+	signals chan os.Signal
+	done    chan struct{}
+
+	mu     sync.Mutex
+	forced map[int]func() // Run before a forced exit, by registration
+	nextID int
+
+	// Soft is done once an interrupt is received; pass it to loops that pick up new work
+	Soft       context.Context
+	cancelSoft context.CancelFunc
+
+	// Hard is done when in-flight work must be abandoned; pass it to uploads
+	Hard       context.Context
+	cancelHard context.CancelFunc
+}
+
+// handleInterrupts starts watching for signals until stop is called
+func handleInterrupts(rep reporter) *interruptHandler {
+	h := &interruptHandler{
+		signals: make(chan os.Signal, 2),
+		done:    make(chan struct{}),
+		forced:  make(map[int]func()),
+	}
+	h.Soft, h.cancelSoft = context.WithCancel(context.Background())
+	h.Hard, h.cancelHard = context.WithCancel(context.Background())
+	signal.Notify(h.signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-h.signals:
+		case <-h.done:
+			return
+		}
+
+		h.cancelSoft()
+		rep.Warning(fmt.Errorf("interrupted: finishing the current upload and saving progress (interrupt again to exit now)"))
+
+		timer := time.NewTimer(shutdownTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			rep.Warning(fmt.Errorf("upload did not finish within %s, abandoning it", shutdownTimeout))
+			h.cancelHard()
+			select {
+			case <-h.signals:
+			case <-h.done:
+				return
+			}
+		case <-h.signals:
+		case <-h.done:
+			return
+		}
+
+		h.cancelHard()
+		fmt.Fprintln(os.Stderr, "Forced exit")
+		h.mu.Lock()
+		for _, fn := range h.forced {
+			fn()
+		}
+		os.Exit(ExitInterrupted)
+	}()
+
+	return h
+}
+
+// onForce registers fn to run before a forced exit; it should save what it can
+// Call the returned function once fn is no longer needed
+func (h *interruptHandler) onForce(fn func()) (remove func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := h.nextID
+	h.nextID++
+	h.forced[id] = fn
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.forced, id)
+	}
+}
+
+// Stopping reports whether an interrupt has been received
+func (h *interruptHandler) Stopping() bool {
+	return h.Soft.Err() != nil
+}
+
+// stop restores default signal handling and releases the contexts
+func (h *interruptHandler) stop() {
+	signal.Stop(h.signals)
+	close(h.done)
+	h.cancelSoft()
+	h.cancelHard()
+}
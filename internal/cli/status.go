@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Status lists every import recorded in the state directory with its progress,
+// last run time and outstanding failures
+func Status(opts Options) error {
+	store, err := openExistingStore(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open state directory: %w", err)
+	}
+
+	entries, err := store.Entries()
+	if err != nil {
+		return fmt.Errorf("failed to read catalogue: %w", err)
+	}
+
+	fmt.Printf("State directory: %s\n\n", store.Dir())
+
+	if len(entries) == 0 {
+		fmt.Println("No imports recorded")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAST RUN\tSTATUS\tPROGRESS\tFAILED\tUSER\tDESTINATION\tPST")
+	for _, entry := range entries {
+		status := "in progress"
+		if entry.Complete {
+			status = "complete"
+		}
+
+		progress := fmt.Sprintf("%d", entry.UploadedCount)
+		if entry.TotalCount > 0 {
+			progress = fmt.Sprintf("%d/%d", entry.UploadedCount, entry.TotalCount)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			entry.LastRun.Local().Format("2006-01-02 15:04"),
+			status,
+			progress,
+			entry.FailedCount,
+			entry.Username,
+			entry.Destination,
+			entry.PSTPath,
+		)
+	}
+	w.Flush()
+	return nil
+}
+
+// EncryptState encrypts the plaintext files in the state directory, which
+// an import with state encryption enabled refuses to read
+func EncryptState(opts Options) error {
+	if opts.StateEncryption == "" || opts.StateEncryption == "none" {
+		return fmt.Errorf("choose the encryption with --state-encryption passphrase or keyring")
+	}
+
+	store, err := openStore(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open state directory: %w", err)
+	}
+
+	count, err := store.EncryptExisting()
+	if err != nil {
+		return fmt.Errorf("failed to encrypt state: %w", err)
+	}
+	fmt.Printf("Encrypted %d state files in %s\n", count, store.Dir())
+	return nil
+}
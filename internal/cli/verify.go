@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mxguardian/pst-import-tool/internal/imap"
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// Verify checks that every message in the PST exists on the IMAP server,
+// matching by Message-ID in the folder each message would be imported into
+// Missing messages are reported and counted in the error
+func Verify(opts Options) error {
+	fmt.Println("Connecting to IMAP server...")
+	uploader, err := imap.NewUploader(context.Background(), opts.Server, opts.Username, opts.Password)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer uploader.Close()
+	uploader.SetFolderMap(&opts.FolderMap)
+
+	extractor, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+	if err := setFolderTypes(&opts, extractor); err != nil {
+		return err
+	}
+
+	var (
+		serverIDs     map[string]bool
+		folderFound   int
+		folderMissing int
+		totalFound    int
+		totalMissing  int
+		currentFolder string
+	)
+
+	printFolderSummary := func() {
+		if currentFolder != "" {
+			fmt.Printf("[%s] %d found, %d missing\n", currentFolder, folderFound, folderMissing)
+		}
+	}
+
+	err = extractor.Process(
+		context.Background(),
+		func(info *pst.FolderInfo) (bool, error) {
+			folderName := info.Path
+			printFolderSummary()
+			currentFolder = ""
+
+			if reason := opts.Folders.SkipReason(info); reason != "" {
+				fmt.Printf("[%s] skipping (%s)\n", folderName, reason)
+				return true, nil
+			}
+
+			ids, err := uploader.MessageIDs(folderName)
+			if err != nil {
+				return false, fmt.Errorf("folder %s: %w", folderName, err)
+			}
+			serverIDs = ids
+			currentFolder = folderName
+			folderFound = 0
+			folderMissing = 0
+			return false, nil
+		},
+		func(folderName string, msg *pst.Message) error {
+			if serverIDs[msg.ID] {
+				folderFound++
+				totalFound++
+			} else {
+				folderMissing++
+				totalMissing++
+			}
+			return nil
+		},
+		nil,
+	)
+	printFolderSummary()
+
+	if err != nil {
+		return fmt.Errorf("error during verification: %w", err)
+	}
+
+	fmt.Printf("\nVerified: %d found, %d missing\n", totalFound, totalMissing)
+	if totalMissing > 0 {
+		return fmt.Errorf("%d messages missing from the server", totalMissing)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Package config loads the settings file shared by the GUI and the CLI, so a
+// migration recipe can be written once and reused for every mailbox
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/mxguardian/pst-import-tool/internal/carddav"
+	"github.com/mxguardian/pst-import-tool/internal/imap"
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// Environment variables that select the config file and profile when the
+// --config and --profile flags are not given
+const (
+	FileEnv    = "PST_IMPORT_CONFIG"
+	ProfileEnv = "PST_IMPORT_PROFILE"
+)
+
+// Config holds the settings read from a config file
+// Empty values mean "not set": the built-in default or a flag applies
+type Config struct {
+	IMAP    IMAP    `toml:"imap"`
+	CardDAV CardDAV `toml:"carddav"`
+	Import  Import  `toml:"import"`
+	Folders Folders `toml:"folders"`
+	Filters Filters `toml:"filters"`
+	State   State   `toml:"state"`
+	Output  Output  `toml:"output"`
+	Batch   Batch   `toml:"batch"`
+
+	// Profiles are named sets of overrides for the settings above
+	Profiles map[string]toml.Primitive `toml:"profiles"`
+
+	path    string // File the config was read from, empty if none
+	profile string // Profile applied, empty if none
+}
+
+// IMAP holds the mail server settings
+type IMAP struct {
+	Host               string `toml:"host"`
+	Port               int    `toml:"port"`
+	Auth               string `toml:"auth"` // "login" or "plain"
+	CAFile             string `toml:"ca_file"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	Username           string `toml:"username"`
+}
+
+// CardDAV holds the contacts server settings
+type CardDAV struct {
+	URL                string `toml:"url"` // Address book, or server or domain to discover it from
+	CAFile             string `toml:"ca_file"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	AddressBooks       string `toml:"address_books"` // "merged" or "per-folder"
+	Conflict           string `toml:"conflict"`      // "skip", "overwrite" or "merge"
+	MatchContacts      bool   `toml:"match_contacts"`
+	SkipPhotos         bool   `toml:"skip_photos"`   // Leave contact pictures out
+	Groups             string `toml:"groups"`        // Distribution lists: "kind" or "apple"
+	VCardVersion       string `toml:"vcard_version"` // "3.0" or "4.0"
+}
+
+// Import holds what to import
+type Import struct {
+	SkipDeleted bool `toml:"skip_deleted"`
+	SkipSent    bool `toml:"skip_sent"`
+}
+
+// Folders holds which folders are imported, see pst.FolderRules, and where
+// they go, see imap.FolderMap
+type Folders struct {
+	Include       []string `toml:"include"`
+	Exclude       []string `toml:"exclude"`
+	IncludeDrafts bool     `toml:"include_drafts"`
+	IncludeJunk   bool     `toml:"include_junk"`
+
+	Map     string `toml:"map"` // Folder map file
+	Root    string `toml:"root"`
+	Flatten bool   `toml:"flatten"`
+}
+
+// Filters holds which messages are imported; see pst.MessageFilter
+// Dates and sizes take the same forms as the --since and --max-size flags
+type Filters struct {
+	Since          string   `toml:"since"`
+	Before         string   `toml:"before"`
+	From           []string `toml:"from"`
+	ExcludeFrom    []string `toml:"exclude_from"`
+	To             []string `toml:"to"`
+	ExcludeTo      []string `toml:"exclude_to"`
+	Subject        string   `toml:"subject"`
+	ExcludeSubject string   `toml:"exclude_subject"`
+	MaxSize        string   `toml:"max_size"`
+	Class          []string `toml:"class"`
+	ExcludeClass   []string `toml:"exclude_class"`
+}
+
+// State holds where and how resume state is kept
+type State struct {
+	Dir        string `toml:"dir"`
+	Encryption string `toml:"encryption"`
+}
+
+// Output holds how progress is reported
+type Output struct {
+	Format string `toml:"format"` // "text" or "json"
+}
+
+// Batch holds settings for batch imports
+type Batch struct {
+	Parallel int    `toml:"parallel"`
+	LogDir   string `toml:"log_dir"`
+}
+
+// DefaultPath returns the config file used when none is given
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "pst-import", "config.toml"), nil
+}
+
+// Load reads a config file and applies the named profile
+// An empty path uses $PST_IMPORT_CONFIG, then DefaultPath; a missing default
+// file gives an empty config. An empty profile uses $PST_IMPORT_PROFILE.
+func Load(path, profile string) (*Config, error) {
+	if profile == "" {
+		profile = os.Getenv(ProfileEnv)
+	}
+
+	explicit := true
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path == "" {
+		explicit = false
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &Config{}
+	md, err := toml.DecodeFile(path, cfg)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+		if profile != "" {
+			return nil, fmt.Errorf("profile %q not found: no config file at %s", profile, path)
+		}
+		return cfg, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	cfg.path = path
+
+	// Decode every profile so a typo is caught even in profiles not in use
+	for name, primitive := range cfg.Profiles {
+		if err := md.PrimitiveDecode(primitive, &Config{}); err != nil {
+			return nil, fmt.Errorf("config %s: profile %q: %w", path, name, err)
+		}
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("config %s: unknown settings: %s", path, strings.Join(keys, ", "))
+	}
+
+	if profile != "" {
+		primitive, ok := cfg.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %s (have: %s)", profile, path, strings.Join(cfg.ProfileNames(), ", "))
+		}
+		// Settings present in the profile replace the top-level ones
+		if err := md.PrimitiveDecode(primitive, cfg); err != nil {
+			return nil, fmt.Errorf("config %s: profile %q: %w", path, profile, err)
+		}
+		cfg.profile = profile
+	}
+
+	return cfg, nil
+}
+
+// Path returns the file the config was read from, or "" if there was none
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Profile returns the name of the profile applied, or ""
+func (c *Config) Profile() string {
+	return c.profile
+}
+
+// ProfileNames returns the profile names in sorted order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Server returns the IMAP server described by the config
+func (c *Config) Server() imap.Server {
+	return imap.Server{
+		Host:               c.IMAP.Host,
+		Port:               c.IMAP.Port,
+		Auth:               c.IMAP.Auth,
+		CAFile:             c.IMAP.CAFile,
+		InsecureSkipVerify: c.IMAP.InsecureSkipVerify,
+	}
+}
+
+// CardDAVServer returns the CardDAV server described by the config
+func (c *Config) CardDAVServer() carddav.Server {
+	return carddav.Server{
+		URL:                c.CardDAV.URL,
+		CAFile:             c.CardDAV.CAFile,
+		InsecureSkipVerify: c.CardDAV.InsecureSkipVerify,
+	}
+}
+
+// Filter returns the compiled message filter described by the config
+func (c *Config) Filter() (*pst.MessageFilter, error) {
+	f := c.Filters
+	filter := &pst.MessageFilter{
+		Senders:           f.From,
+		ExcludeSenders:    f.ExcludeFrom,
+		Recipients:        f.To,
+		ExcludeRecipients: f.ExcludeTo,
+		Subject:           f.Subject,
+		ExcludeSubject:    f.ExcludeSubject,
+		Classes:           f.Class,
+		ExcludeClasses:    f.ExcludeClass,
+	}
+
+	var err error
+	now := time.Now()
+	if f.Since != "" {
+		if filter.Since, err = pst.ParseDate(f.Since, now); err != nil {
+			return nil, fmt.Errorf("filters.since: %w", err)
+		}
+	}
+	if f.Before != "" {
+		if filter.Before, err = pst.ParseDate(f.Before, now); err != nil {
+			return nil, fmt.Errorf("filters.before: %w", err)
+		}
+	}
+	if f.MaxSize != "" {
+		if filter.MaxSize, err = pst.ParseSize(f.MaxSize); err != nil {
+			return nil, fmt.Errorf("filters.max_size: %w", err)
+		}
+	}
+	if err := filter.Compile(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// FolderMap returns the folder mapping described by the config, read from the
+// map file if there is one
+func (c *Config) FolderMap() (*imap.FolderMap, error) {
+	folderMap := &imap.FolderMap{}
+	if c.Folders.Map != "" {
+		var err error
+		if folderMap, err = imap.LoadFolderMap(c.Folders.Map); err != nil {
+			return nil, err
+		}
+	}
+	if c.Folders.Root != "" {
+		folderMap.Root = c.Folders.Root
+	}
+	folderMap.Flatten = folderMap.Flatten || c.Folders.Flatten
+	if err := folderMap.Compile(); err != nil {
+		return nil, err
+	}
+	return folderMap, nil
+}
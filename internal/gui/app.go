@@ -1,7 +1,11 @@
 package gui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -11,8 +15,23 @@ import (
 	"fyne.io/fyne/v2/widget"
 
 	"github.com/mxguardian/pst-import-tool/internal/carddav"
+	"github.com/mxguardian/pst-import-tool/internal/config"
 	"github.com/mxguardian/pst-import-tool/internal/imap"
+	"github.com/mxguardian/pst-import-tool/internal/progress"
 	"github.com/mxguardian/pst-import-tool/internal/pst"
+	"github.com/mxguardian/pst-import-tool/internal/state"
+)
+
+// progressInterval is the minimum time between progress bar updates
+const progressInterval = 250 * time.Millisecond
+
+// appID identifies the app to Fyne, which keys stored preferences by it
+const appID = "net.mxguardian.pst-import"
+
+// Preference keys remembered between launches
+const (
+	prefUsername = "username"
+	prefPSTPath  = "pstPath"
 )
 
 // App represents the main GUI application
@@ -31,25 +50,63 @@ type App struct {
 	statusLabel   *widget.Label
 	logText       *widget.Entry
 
+	// Settings from the config file
+	config  *config.Config
+	rules   pst.FolderRules
+	filter  *pst.MessageFilter
+	folders *imap.FolderMap
+	// Why the config file can't be used; no import starts until it's fixed
+	configErr error
+
 	// State
 	pstPath   string
 	importing bool
-	cancel    chan struct{}
+	ctx       context.Context // Cancelled by the Cancel button
+	cancel    context.CancelFunc
 }
 
 // NewApp creates a new GUI application
 func NewApp() *App {
-	return &App{
-		cancel: make(chan struct{}),
-	}
+	return &App{}
 }
 
 // Run starts the GUI application
 func (a *App) Run() {
-	a.fyneApp = app.New()
+	a.fyneApp = app.NewWithID(appID)
 	a.mainWindow = a.fyneApp.NewWindow("MXGuardian PST Import")
 
+	// The same config file and profile as the CLI, chosen by environment
+	cfg, err := config.Load("", "")
+	if err != nil {
+		cfg = &config.Config{}
+	}
+	a.config = cfg
+	a.rules = pst.FolderRules{
+		Include:       cfg.Folders.Include,
+		Exclude:       cfg.Folders.Exclude,
+		SkipDeleted:   cfg.Import.SkipDeleted,
+		SkipSent:      cfg.Import.SkipSent,
+		IncludeDrafts: cfg.Folders.IncludeDrafts,
+		IncludeJunk:   cfg.Folders.IncludeJunk,
+	}
+	if err == nil {
+		err = a.rules.Compile()
+	}
+	if err == nil {
+		a.filter, err = cfg.Filter()
+	}
+	if err == nil {
+		a.folders, err = cfg.FolderMap()
+	}
+
 	a.buildUI()
+	if err != nil {
+		a.configErr = err
+		a.log("Config error: " + err.Error())
+		a.showError("Config error", err)
+	} else if cfg.Path() != "" {
+		a.log("Using config: " + cfg.Path())
+	}
 
 	a.mainWindow.Resize(fyne.NewSize(500, 400))
 	a.mainWindow.ShowAndRun()
@@ -59,6 +116,10 @@ func (a *App) buildUI() {
 	// PST file selection
 	a.pstPathLabel = widget.NewLabel("No file selected")
 	a.pstPathLabel.Wrapping = fyne.TextWrapWord
+	if path := a.fyneApp.Preferences().String(prefPSTPath); path != "" {
+		a.pstPath = path
+		a.pstPathLabel.SetText(path)
+	}
 
 	a.pstSelectBtn = widget.NewButton("Select PST File...", a.selectPSTFile)
 
@@ -71,12 +132,17 @@ func (a *App) buildUI() {
 	// IMAP credentials
 	a.usernameEntry = widget.NewEntry()
 	a.usernameEntry.SetPlaceHolder("email@example.com")
+	// A username in the config wins over the one remembered from last time
+	a.usernameEntry.SetText(a.fyneApp.Preferences().String(prefUsername))
+	if a.config.IMAP.Username != "" {
+		a.usernameEntry.SetText(a.config.IMAP.Username)
+	}
 
 	a.passwordEntry = widget.NewPasswordEntry()
 	a.passwordEntry.SetPlaceHolder("Password")
 
 	credentialsForm := container.NewVBox(
-		widget.NewLabel("IMAP Credentials (mail.mxguardian.net):"),
+		widget.NewLabel(fmt.Sprintf("IMAP Credentials (%s):", a.server().Host)),
 		widget.NewLabel("Username:"),
 		a.usernameEntry,
 		widget.NewLabel("Password:"),
@@ -143,6 +209,10 @@ func (a *App) selectPSTFile() {
 
 func (a *App) startImport() {
 	// Validate inputs
+	if a.configErr != nil {
+		a.showError("Config error", a.configErr)
+		return
+	}
 	if a.pstPath == "" {
 		dialog.ShowError(fmt.Errorf("please select a PST file"), a.mainWindow)
 		return
@@ -156,8 +226,11 @@ func (a *App) startImport() {
 		return
 	}
 
+	a.fyneApp.Preferences().SetString(prefPSTPath, a.pstPath)
+	a.fyneApp.Preferences().SetString(prefUsername, a.usernameEntry.Text)
+
 	a.importing = true
-	a.cancel = make(chan struct{})
+	a.ctx, a.cancel = context.WithCancel(context.Background())
 	a.setUIEnabled(false)
 
 	go a.runImport()
@@ -165,7 +238,7 @@ func (a *App) startImport() {
 
 func (a *App) cancelImport() {
 	if a.importing {
-		close(a.cancel)
+		a.cancel()
 		a.log("Cancelling import...")
 	}
 }
@@ -173,14 +246,15 @@ func (a *App) cancelImport() {
 func (a *App) runImport() {
 	defer func() {
 		a.importing = false
+		a.cancel()
 		a.setUIEnabled(true)
 	}()
 
 	// Test IMAP connection first
 	a.setStatus("Testing IMAP connection...")
-	a.log("Connecting to mail.mxguardian.net...")
+	a.log(fmt.Sprintf("Connecting to %s...", a.server().Host))
 
-	if err := imap.TestConnection(a.usernameEntry.Text, a.passwordEntry.Text); err != nil {
+	if err := imap.TestConnection(a.ctx, a.server(), a.usernameEntry.Text, a.passwordEntry.Text); err != nil {
 		a.log("Connection failed: " + err.Error())
 		a.showError("IMAP connection failed", err)
 		return
@@ -199,43 +273,92 @@ func (a *App) runImport() {
 	}
 	defer extractor.Close()
 
+	extractor.SetFilter(a.filter)
+	extractor.SetSkipPhotos(a.config.CardDAV.SkipPhotos)
+	if err := extractor.SetGroupStyle(a.config.CardDAV.Groups); err != nil {
+		a.log("Config error: " + err.Error())
+		a.showError("Config error", err)
+		return
+	}
+	if err := extractor.SetVCardVersion(a.config.CardDAV.VCardVersion); err != nil {
+		a.log("Config error: " + err.Error())
+		a.showError("Config error", err)
+		return
+	}
 	if err := extractor.Open(a.pstPath); err != nil {
 		a.log("Failed to open PST: " + err.Error())
 		a.showError("Failed to open PST file", err)
 		return
 	}
 
+	// Count messages and bytes up front so progress can show percent and ETA
+	totals, err := extractor.Prescan()
+	if err != nil {
+		a.log("Failed to scan PST: " + err.Error())
+		a.showError("Failed to scan PST file", err)
+		return
+	}
+	if a.folders == nil {
+		a.folders = &imap.FolderMap{}
+	}
+	folders := make([]pst.FolderInfo, len(totals))
+	var (
+		totalMessages int
+		totalBytes    int64
+	)
+	for i, folder := range totals {
+		folders[i] = folder.FolderInfo
+		if a.rules.SkipReason(&folder.FolderInfo) != "" {
+			continue
+		}
+		totalMessages += folder.Messages
+		totalBytes += folder.Bytes
+	}
+	a.folders.SetFolderTypes(folders)
+	a.log(fmt.Sprintf("Found %d messages", totalMessages))
+	tracker := progress.NewTracker(totalMessages, totalBytes)
+	a.setProgress(0)
+
 	// Connect to IMAP for upload
 	a.setStatus("Connecting to IMAP...")
 
-	uploader, err := imap.NewUploader(a.usernameEntry.Text, a.passwordEntry.Text)
+	uploader, err := imap.NewUploader(a.ctx, a.server(), a.usernameEntry.Text, a.passwordEntry.Text)
 	if err != nil {
 		a.log("Upload connection failed: " + err.Error())
 		a.showError("Failed to connect to IMAP server", err)
 		return
 	}
 	defer uploader.Close()
+	uploader.SetFolderMap(a.folders)
 
 	// Stream messages
 	a.log("Streaming messages...")
 
 	var (
-		currentFolder  string
-		totalUploaded  int
-		totalErrors    int
-		cancelled      bool
+		currentFolder string
+		totalUploaded int
+		totalErrors   int
+		totalFiltered int
+		lastFiltered  bool
+		lastProgress  time.Time
 	)
 
+	extractor.SetSkipCallback(func(folderName, reason string, count int) {
+		if strings.HasPrefix(reason, pst.SkipFiltered) {
+			lastFiltered = true
+			totalFiltered += count
+		}
+	})
+
 	err = extractor.Process(
+		a.ctx,
 		// On folder
-		func(folderName string) (skip bool, err error) {
-			select {
-			case <-a.cancel:
-				cancelled = true
-				return false, fmt.Errorf("cancelled")
-			default:
+		func(info *pst.FolderInfo) (skip bool, err error) {
+			folderName := info.Path
+			if reason := a.rules.SkipReason(info); reason != "" {
+				a.log(fmt.Sprintf("Skipping: %s (%s)", folderName, reason))
+				return true, nil
 			}
-
 			if currentFolder != folderName {
 				currentFolder = folderName
 				a.log(fmt.Sprintf("Processing: %s", folderName))
@@ -244,26 +367,36 @@ func (a *App) runImport() {
 		},
 		// On message
 		func(folderName string, msg *pst.Message) error {
-			select {
-			case <-a.cancel:
-				cancelled = true
-				return fmt.Errorf("cancelled")
-			default:
-			}
-
-			if err := uploader.Upload(folderName, msg); err != nil {
+			if err := uploader.Upload(a.ctx, folderName, msg); err != nil {
+				if a.ctx.Err() != nil {
+					return err
+				}
 				totalErrors++
 				return nil
 			}
 
 			totalUploaded++
-			a.setStatus(fmt.Sprintf("Uploaded %d messages...", totalUploaded))
 
 			return nil
 		},
-		nil,
+		// After each item
+		func(folderName string, size int64) {
+			if lastFiltered {
+				tracker.Skip(1, size)
+				lastFiltered = false
+			} else {
+				tracker.Add(1, size)
+			}
+			if time.Since(lastProgress) >= progressInterval {
+				lastProgress = time.Now()
+				snapshot := tracker.Snapshot()
+				a.setProgress(snapshot.Fraction())
+				a.setStatus(snapshot.String())
+			}
+		},
 	)
 
+	cancelled := a.ctx.Err() != nil
 	if cancelled {
 		a.setStatus("Import cancelled")
 		a.log(fmt.Sprintf("Cancelled after %d messages", totalUploaded))
@@ -276,7 +409,7 @@ func (a *App) runImport() {
 
 	a.setStatus("Import complete!")
 	a.setProgress(1.0)
-	a.log(fmt.Sprintf("Completed: %d messages uploaded, %d errors", totalUploaded, totalErrors))
+	a.log(fmt.Sprintf("Completed: %d messages uploaded, %d filtered, %d errors", totalUploaded, totalFiltered, totalErrors))
 
 	// Sync contacts to CardDAV
 	contactsUploaded, contactsErrors := a.syncContacts(extractor)
@@ -293,27 +426,51 @@ func (a *App) runImport() {
 	})
 }
 
-func (a *App) syncContacts(extractor *pst.Extractor) (uploaded, errors int) {
+func (a *App) syncContacts(extractor *pst.Extractor) (uploaded, failed int) {
 	a.setStatus("Syncing contacts...")
 	a.log("Connecting to CardDAV...")
 
-	cardDAVUploader, err := carddav.NewUploader(a.usernameEntry.Text, a.passwordEntry.Text)
+	cardDAVUploader, err := carddav.NewUploader(a.ctx, a.config.CardDAVServer(), a.usernameEntry.Text, a.passwordEntry.Text)
 	if err != nil {
 		a.log("CardDAV connection failed: " + err.Error())
 		return 0, 0
 	}
 	defer cardDAVUploader.Close()
+	if err := cardDAVUploader.SetAddressBooks(a.config.CardDAV.AddressBooks); err != nil {
+		a.log("CardDAV: " + err.Error())
+		return 0, 0
+	}
+	if err := cardDAVUploader.SetConflictPolicy(a.config.CardDAV.Conflict); err != nil {
+		a.log("CardDAV: " + err.Error())
+		return 0, 0
+	}
+
+	cardDAVUploader.SetMatchExisting(a.config.CardDAV.MatchContacts)
+	contactState, err := a.loadContactState(cardDAVUploader.AddressBook())
+	if err != nil {
+		a.log("Contacts not synced: " + err.Error())
+		return 0, 0
+	}
+	cardDAVUploader.SetETagStore(contactState)
+	defer func() {
+		if err := contactState.Save(); err != nil {
+			a.log("Failed to save contact state: " + err.Error())
+		}
+	}()
 
+	skipped := 0
 	err = extractor.ProcessContacts(
+		a.ctx,
 		func(contact *pst.Contact) error {
-			select {
-			case <-a.cancel:
-				return fmt.Errorf("cancelled")
-			default:
-			}
-
-			if err := cardDAVUploader.Upload(contact); err != nil {
-				errors++
+			if _, err := cardDAVUploader.Upload(a.ctx, contact); err != nil {
+				if a.ctx.Err() != nil {
+					return err
+				}
+				if errors.Is(err, carddav.ErrConflict) {
+					skipped++ // Changed on the server since an earlier import
+					return nil
+				}
+				failed++
 				return nil
 			}
 			uploaded++
@@ -327,13 +484,45 @@ func (a *App) syncContacts(extractor *pst.Extractor) (uploaded, errors int) {
 		a.log("Contact sync error: " + err.Error())
 	}
 
-	if uploaded > 0 || errors > 0 {
-		a.log(fmt.Sprintf("Contacts: %d synced, %d errors", uploaded, errors))
-	} else {
+	if skipped > 0 {
+		a.log(fmt.Sprintf("Contacts: %d changed on the server and left alone", skipped))
+	}
+	if uploaded > 0 || failed > 0 {
+		a.log(fmt.Sprintf("Contacts: %d synced, %d errors", uploaded, failed))
+	} else if skipped == 0 {
 		a.log("No contacts found")
 	}
 
-	return uploaded, errors
+	return uploaded, failed
+}
+
+// loadContactState loads where earlier syncs stored each contact on the
+// server, kept in the state directory the CLI uses
+func (a *App) loadContactState(addressBook string) (*state.ContactState, error) {
+	store, err := state.NewStore(a.config.State.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state directory: %w", err)
+	}
+	if err := store.UseEncryption(a.config.State.Encryption, true); err != nil {
+		return nil, err
+	}
+	contactState, err := store.NewContactState(a.pstPath, a.usernameEntry.Text, addressBook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize contact state: %w", err)
+	}
+	if err := contactState.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load contact state: %w", err)
+	}
+	return contactState, nil
+}
+
+// server returns the IMAP server to import into, with defaults filled in
+func (a *App) server() imap.Server {
+	server := a.config.Server()
+	if server.Host == "" {
+		server.Host = imap.IMAPServer
+	}
+	return server
 }
 
 func (a *App) setUIEnabled(enabled bool) {
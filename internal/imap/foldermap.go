@@ -0,0 +1,228 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// FolderMap changes which IMAP folder each PST folder is uploaded to, by full
+// PST folder path as passed to pst.FolderCallback
+// The zero value maps folders with MapFolder.
+//
+// A path is mapped in this order:
+//  1. Overrides: the longest override that is the path or one of its parent
+//     folders gives the IMAP folder, with any subfolders kept below it
+//  2. Rewrites: each regular expression that matches replaces its match in
+//     the path, in order
+//  3. Flatten: only the last folder name is kept
+//  4. Root: the folder goes below Root; without a Root, special folders such
+//     as the Inbox and Sent Items go to their usual place and the rest below
+//     INBOX. Special folders are known by their type once SetFolderTypes has
+//     been called, and by their English names, as MapFolder does, until then
+type FolderMap struct {
+	Root      string            `toml:"root"`      // IMAP folder to put every folder under, e.g. "INBOX.Archive.2015"
+	Flatten   bool              `toml:"flatten"`   // Drop parent folders, keeping only the folder's own name
+	Overrides map[string]string `toml:"overrides"` // PST folder path → IMAP folder, ignoring case
+	Rewrites  []FolderRewrite   `toml:"rewrite"`
+
+	overrides []folderOverride // Longest path first
+	rewrites  []*regexp.Regexp
+	compiled  bool
+	special   map[string]pst.FolderType // Special mail folders by lower-case path, from SetFolderTypes
+	top       string                    // Path of the folder the special folders are in, from SetFolderTypes
+}
+
+// FolderRewrite replaces the part of a PST folder path matching a regular
+// expression, using Go regexp replacement syntax ($1 for the first group)
+type FolderRewrite struct {
+	Match   string `toml:"match"`
+	Replace string `toml:"replace"`
+}
+
+// folderOverride is one entry of FolderMap.Overrides
+type folderOverride struct {
+	path    string // Without a trailing slash
+	mailbox string
+}
+
+// LoadFolderMap reads a folder map from a TOML file
+func LoadFolderMap(path string) (*FolderMap, error) {
+	m := &FolderMap{}
+	md, err := toml.DecodeFile(path, m)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("folder map %s not found", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read folder map %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("folder map %s: unknown settings: %s", path, strings.Join(keys, ", "))
+	}
+	if err := m.Compile(); err != nil {
+		return nil, fmt.Errorf("folder map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Compile checks and compiles the rules
+// Map compiles on first use, but only Compile reports bad rules
+func (m *FolderMap) Compile() error {
+	m.overrides = m.overrides[:0]
+	for path, mailbox := range m.Overrides {
+		if strings.TrimSpace(mailbox) == "" {
+			return fmt.Errorf("override for %q has no IMAP folder", path)
+		}
+		m.overrides = append(m.overrides, folderOverride{
+			path:    strings.TrimSuffix(strings.TrimSpace(path), "/"),
+			mailbox: strings.TrimSpace(mailbox),
+		})
+	}
+	sort.Slice(m.overrides, func(i, j int) bool {
+		return len(m.overrides[i].path) > len(m.overrides[j].path)
+	})
+
+	m.rewrites = m.rewrites[:0]
+	for _, rewrite := range m.Rewrites {
+		re, err := regexp.Compile(rewrite.Match)
+		if err != nil {
+			return fmt.Errorf("invalid rewrite pattern %q: %w", rewrite.Match, err)
+		}
+		m.rewrites = append(m.rewrites, re)
+	}
+
+	m.compiled = true
+	return nil
+}
+
+// IsZero reports whether the map leaves every folder where MapFolder puts it
+func (m *FolderMap) IsZero() bool {
+	return m == nil || (m.Root == "" && !m.Flatten && len(m.Overrides) == 0 && len(m.Rewrites) == 0 && m.special == nil)
+}
+
+// SetFolderTypes tells the map which of the PST's folders are the special
+// mail folders, so they are found by type rather than by their English
+// names, which a PST in another language doesn't use
+// Only folders directly below the top of the mailbox count, as that is where
+// Outlook keeps them. The top folder is then dropped from the IMAP folders
+// whatever it is called, like "Top of Personal Folders" is.
+func (m *FolderMap) SetFolderTypes(folders []pst.FolderInfo) {
+	m.special = make(map[string]pst.FolderType)
+	m.top = ""
+	for _, folder := range folders {
+		if folder.Depth != 1 || specialMailbox(folder.Type) == "" {
+			continue
+		}
+		m.special[strings.ToLower(folder.Path)] = folder.Type
+		if i := strings.LastIndex(folder.Path, "/"); i >= 0 {
+			m.top = folder.Path[:i]
+		}
+	}
+}
+
+// Map returns the IMAP folder the PST folder at folderPath is uploaded to
+// A nil map is the same as MapFolder.
+func (m *FolderMap) Map(folderPath string) string {
+	if m.IsZero() {
+		return MapFolder(folderPath)
+	}
+	if !m.compiled {
+		m.Compile()
+	}
+
+	folderPath = strings.TrimSpace(folderPath)
+	for _, override := range m.overrides {
+		n := len(override.path)
+		if len(folderPath) < n || !strings.EqualFold(folderPath[:n], override.path) {
+			continue
+		}
+		if len(folderPath) == n {
+			return override.mailbox
+		}
+		if folderPath[n] == '/' {
+			below := folderParts(folderPath[n+1:])
+			return strings.Join(append([]string{override.mailbox}, below...), ".")
+		}
+	}
+
+	for i, re := range m.rewrites {
+		folderPath = re.ReplaceAllString(folderPath, m.Rewrites[i].Replace)
+	}
+
+	parts := folderParts(folderPath)
+	if m.Flatten && len(parts) > 1 {
+		parts = parts[len(parts)-1:]
+	}
+
+	switch {
+	case m.Root != "":
+		return strings.Join(append([]string{strings.TrimSuffix(m.Root, ".")}, parts...), ".")
+	case m.special != nil:
+		return m.mapByType(folderPath, parts)
+	default:
+		return mapFolderParts(parts)
+	}
+}
+
+// mapByType puts a folder below the special folder it is in, or is, with
+// the folders in between, and any other folder below INBOX
+// parts are the folder's names as Map has them, flattened if need be.
+func (m *FolderMap) mapByType(folderPath string, parts []string) string {
+	for prefix := folderPath; prefix != ""; {
+		if mailbox := specialMailbox(m.special[strings.ToLower(prefix)]); mailbox != "" {
+			below := folderParts(strings.TrimPrefix(folderPath[len(prefix):], "/"))
+			if len(below) == 0 {
+				return mailbox
+			}
+			if m.Flatten {
+				break
+			}
+			return strings.Join(append([]string{mailbox}, below...), ".")
+		}
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+
+	if n := len(m.top); n > 0 && len(folderPath) >= n && strings.EqualFold(folderPath[:n], m.top) {
+		if len(folderPath) == n {
+			return "INBOX"
+		}
+		if folderPath[n] == '/' && !m.Flatten {
+			parts = folderParts(folderPath[n+1:])
+		}
+	}
+	return strings.Join(append([]string{"INBOX"}, parts...), ".")
+}
+
+// specialMailbox returns the IMAP folder a special mail folder goes to, or ""
+// for any other type of folder
+func specialMailbox(folderType pst.FolderType) string {
+	switch folderType {
+	case pst.FolderInbox:
+		return "INBOX"
+	case pst.FolderSent:
+		return "INBOX.Sent"
+	case pst.FolderDeleted:
+		return "INBOX.Trash"
+	case pst.FolderDrafts:
+		return "INBOX.Drafts"
+	case pst.FolderJunk:
+		return "INBOX.Junk"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,126 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/mxguardian/pst-import-tool/internal/pst"
+)
+
+// checkMap fails the test unless m maps each PST folder path to its IMAP folder
+func checkMap(t *testing.T, m *FolderMap, want map[string]string) {
+	t.Helper()
+	for folderPath, mailbox := range want {
+		if got := m.Map(folderPath); got != mailbox {
+			t.Errorf("Map(%q) = %q, want %q", folderPath, got, mailbox)
+		}
+	}
+}
+
+func TestFolderMapZero(t *testing.T) {
+	var m *FolderMap
+	checkMap(t, m, map[string]string{
+		"Top of Personal Folders":                 "INBOX",
+		"Top of Personal Folders/Inbox":           "INBOX",
+		"Top of Personal Folders/Inbox/Invoices":  "INBOX.Invoices",
+		"Top of Personal Folders/Sent Items":      "INBOX.Sent",
+		"Top of Personal Folders/Deleted Items":   "INBOX.Trash",
+		"Top of Personal Folders/Projects/Alpha":  "INBOX.Projects.Alpha",
+		"Top of Personal Folders/Q1: Plans/Draft": "INBOX.Q1- Plans.Draft",
+	})
+}
+
+func TestFolderMapOverrides(t *testing.T) {
+	m := &FolderMap{Overrides: map[string]string{
+		"Top of Personal Folders/Projects":       "INBOX.Work",
+		"top of personal folders/projects/alpha": "INBOX.Alpha",
+		"Top of Personal Folders/Sent Items/":    "INBOX.Sent.Old",
+	}}
+	if err := m.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, m, map[string]string{
+		"Top of Personal Folders/Projects":            "INBOX.Work",
+		"Top of Personal Folders/Projects/Beta":       "INBOX.Work.Beta",
+		"Top of Personal Folders/Projects/Alpha":      "INBOX.Alpha", // The longest override wins
+		"Top of Personal Folders/Projects/Alpha/2015": "INBOX.Alpha.2015",
+		"Top of Personal Folders/Sent Items":          "INBOX.Sent.Old",
+		"Top of Personal Folders/ProjectsOld":         "INBOX.ProjectsOld", // Not below the override
+		"Top of Personal Folders/Inbox":               "INBOX",
+	})
+}
+
+func TestFolderMapRewrites(t *testing.T) {
+	m := &FolderMap{Rewrites: []FolderRewrite{
+		{Match: `^Top of Personal Folders/Archive (\d{4})`, Replace: "Top of Personal Folders/Archive/$1"},
+		{Match: `(?i)/old$`, Replace: "/Legacy"},
+	}}
+	if err := m.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, m, map[string]string{
+		"Top of Personal Folders/Archive 2015":         "INBOX.Archive.2015",
+		"Top of Personal Folders/Archive 2015/Clients": "INBOX.Archive.2015.Clients",
+		"Top of Personal Folders/Projects/OLD":         "INBOX.Projects.Legacy",
+		"Top of Personal Folders/Projects":             "INBOX.Projects",
+	})
+}
+
+func TestFolderMapBadRewrite(t *testing.T) {
+	m := &FolderMap{Rewrites: []FolderRewrite{{Match: "(", Replace: ""}}}
+	if err := m.Compile(); err == nil {
+		t.Error("Compile accepted an invalid pattern")
+	}
+}
+
+func TestFolderMapFlatten(t *testing.T) {
+	m := &FolderMap{Flatten: true}
+	checkMap(t, m, map[string]string{
+		"Top of Personal Folders/Projects/Alpha":  "INBOX.Alpha",
+		"Top of Personal Folders/Inbox/Invoices":  "INBOX.Invoices",
+		"Top of Personal Folders/Sent Items":      "INBOX.Sent",
+		"Top of Personal Folders/Archive/Inbox":   "INBOX",
+		"Top of Personal Folders/Archive/Clients": "INBOX.Clients",
+	})
+}
+
+func TestFolderMapRoot(t *testing.T) {
+	m := &FolderMap{Root: "INBOX.Archive.2015."}
+	checkMap(t, m, map[string]string{
+		"Top of Personal Folders":                "INBOX.Archive.2015",
+		"Top of Personal Folders/Inbox":          "INBOX.Archive.2015.Inbox",
+		"Top of Personal Folders/Sent Items":     "INBOX.Archive.2015.Sent Items",
+		"Top of Personal Folders/Projects/Alpha": "INBOX.Archive.2015.Projects.Alpha",
+	})
+
+	m = &FolderMap{Root: "INBOX.Archive", Flatten: true}
+	checkMap(t, m, map[string]string{
+		"Top of Personal Folders/Projects/Alpha": "INBOX.Archive.Alpha",
+	})
+}
+
+func TestFolderMapFolderTypes(t *testing.T) {
+	m := &FolderMap{}
+	m.SetFolderTypes([]pst.FolderInfo{
+		{Path: "Oberste Ebene der Outlook-Datei", Type: pst.FolderMail},
+		{Path: "Oberste Ebene der Outlook-Datei/Posteingang", Depth: 1, Type: pst.FolderInbox},
+		{Path: "Oberste Ebene der Outlook-Datei/Gesendete Elemente", Depth: 1, Type: pst.FolderSent},
+		{Path: "Oberste Ebene der Outlook-Datei/Gelöschte Elemente", Depth: 1, Type: pst.FolderDeleted},
+		{Path: "Oberste Ebene der Outlook-Datei/Entwürfe", Depth: 1, Type: pst.FolderDrafts},
+		{Path: "Oberste Ebene der Outlook-Datei/Junk-E-Mail", Depth: 1, Type: pst.FolderJunk},
+		{Path: "Oberste Ebene der Outlook-Datei/Projekte", Depth: 1, Type: pst.FolderMail},
+		{Path: "Oberste Ebene der Outlook-Datei/Projekte/Posteingang", Depth: 2, Type: pst.FolderInbox}, // Not directly below the top
+	})
+	checkMap(t, m, map[string]string{
+		"Oberste Ebene der Outlook-Datei":                        "INBOX",
+		"Oberste Ebene der Outlook-Datei/Posteingang":            "INBOX",
+		"Oberste Ebene der Outlook-Datei/Posteingang/Rechnungen": "INBOX.Rechnungen",
+		"Oberste Ebene der Outlook-Datei/Gesendete Elemente":     "INBOX.Sent",
+		"Oberste Ebene der Outlook-Datei/Gelöschte Elemente":     "INBOX.Trash",
+		"Oberste Ebene der Outlook-Datei/Gelöschte Elemente/Alt": "INBOX.Trash.Alt",
+		"Oberste Ebene der Outlook-Datei/Entwürfe":               "INBOX.Drafts",
+		"Oberste Ebene der Outlook-Datei/Junk-E-Mail":            "INBOX.Junk",
+		"Oberste Ebene der Outlook-Datei/Projekte":               "INBOX.Projekte",
+		"Oberste Ebene der Outlook-Datei/Projekte/Posteingang":   "INBOX.Projekte.Posteingang",
+		"Oberste Ebene der Outlook-Datei/Sent Items":             "INBOX.Sent Items", // Only the type counts
+	})
+}
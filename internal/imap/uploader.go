@@ -1,13 +1,20 @@
 package imap
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-sasl"
 
 	"github.com/mxguardian/pst-import-tool/internal/pst"
 )
@@ -17,49 +24,196 @@ const (
 	IMAPPort   = 993
 )
 
+// Authentication methods
+const (
+	AuthLogin = "login" // IMAP LOGIN command
+	AuthPlain = "plain" // SASL PLAIN via AUTHENTICATE
+)
+
+// Server describes the IMAP server to upload to
+// The zero value is the MXGuardian server
+type Server struct {
+	Host string
+	Port int
+	Auth string // AuthLogin (default) or AuthPlain
+
+	// TLS settings. The connection always uses implicit TLS.
+	CAFile             string // PEM bundle to trust instead of the system roots
+	InsecureSkipVerify bool   // Don't verify the server certificate
+}
+
+// withDefaults fills in unset fields
+func (s Server) withDefaults() Server {
+	if s.Host == "" {
+		s.Host = IMAPServer
+	}
+	if s.Port == 0 {
+		s.Port = IMAPPort
+	}
+	if s.Auth == "" {
+		s.Auth = AuthLogin
+	}
+	return s
+}
+
+// Destination identifies the server messages are uploaded to
+func (s Server) Destination() string {
+	s = s.withDefaults()
+	return fmt.Sprintf("imaps://%s:%d", s.Host, s.Port)
+}
+
+// tlsConfig builds the TLS configuration for the server
+func (s Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         s.Host,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", s.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// Errors returned by NewUploader, wrapping the underlying error, so callers
+// can tell a bad password from an unreachable server
+var (
+	ErrConnection = errors.New("failed to connect to IMAP server")
+	ErrAuth       = errors.New("IMAP login failed")
+)
+
 // Uploader handles uploading messages to IMAP
 type Uploader struct {
 	client         *client.Client
+	conn           net.Conn // Closed to abort a command when its context is done
+	aborted        bool     // A command was aborted; the connection is gone
 	username       string
+	folders        *FolderMap // nil uses MapFolder
 	createdFolders map[string]bool
 }
 
 // NewUploader creates a new IMAP uploader and connects to the server
-func NewUploader(username, password string) (*Uploader, error) {
-	addr := fmt.Sprintf("%s:%d", IMAPServer, IMAPPort)
+// ctx bounds the connection and login
+func NewUploader(ctx context.Context, server Server, username, password string) (*Uploader, error) {
+	server = server.withDefaults()
+	addr := net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+
+	var login func(c *client.Client) error
+	switch server.Auth {
+	case AuthLogin:
+		login = func(c *client.Client) error { return c.Login(username, password) }
+	case AuthPlain:
+		login = func(c *client.Client) error { return c.Authenticate(sasl.NewPlainClient("", username, password)) }
+	default:
+		return nil, fmt.Errorf("unknown IMAP auth method %q (use %s or %s)", server.Auth, AuthLogin, AuthPlain)
+	}
+
+	tlsConfig, err := server.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// Connect with TLS
-	c, err := client.DialTLS(addr, &tls.Config{
-		ServerName: IMAPServer,
+	dialer := &tls.Dialer{Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+
+	u := &Uploader{
+		conn:           conn,
+		username:       username,
+		createdFolders: make(map[string]bool),
+	}
+
+	// Read the greeting
+	err = u.withContext(ctx, func() error {
+		var err error
+		u.client, err = client.New(conn)
+		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
 	}
 
 	// Login
-	if err := c.Login(username, password); err != nil {
-		c.Logout()
-		return nil, fmt.Errorf("IMAP login failed: %w", err)
+	if err := u.withContext(ctx, func() error { return login(u.client) }); err != nil {
+		u.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrAuth, err)
 	}
 
-	return &Uploader{
-		client:         c,
-		username:       username,
-		createdFolders: make(map[string]bool),
-	}, nil
+	return u, nil
+}
+
+// withContext runs an IMAP command, aborting it if ctx is done first
+// go-imap has no context support, so aborting closes the connection, after
+// which the uploader can't be used again
+func (u *Uploader) withContext(ctx context.Context, command func() error) error {
+	if u.aborted {
+		return net.ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- command()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		u.aborted = true
+		u.conn.Close() // Unblocks the command
+		<-done
+		return ctx.Err()
+	}
 }
 
 // Close disconnects from the IMAP server
 func (u *Uploader) Close() error {
+	if u.aborted {
+		return nil
+	}
 	if u.client != nil {
 		return u.client.Logout()
 	}
-	return nil
+	return u.conn.Close()
+}
+
+// SetFolderMap sets where PST folders are uploaded to; nil uses MapFolder
+func (u *Uploader) SetFolderMap(folders *FolderMap) {
+	u.folders = folders
 }
 
 // Upload uploads a single message to the appropriate IMAP folder
-func (u *Uploader) Upload(folderName string, msg *pst.Message) error {
-	imapFolder := mapToIMAPFolder(folderName)
+// If ctx is done before the server accepts the message the upload is aborted
+// and the uploader can't be used again
+func (u *Uploader) Upload(ctx context.Context, folderName string, msg *pst.Message) error {
+	return u.withContext(ctx, func() error { return u.upload(folderName, msg) })
+}
+
+// upload appends a message, creating its folder first if needed
+func (u *Uploader) upload(folderName string, msg *pst.Message) error {
+	imapFolder := u.folders.Map(folderName)
 
 	// Create IMAP folder if needed
 	if !u.createdFolders[imapFolder] {
@@ -80,6 +234,79 @@ func (u *Uploader) Upload(folderName string, msg *pst.Message) error {
 	return u.client.Append(imapFolder, []string{imap.SeenFlag}, msgDate, literal)
 }
 
+// MessageIDs returns the Message-IDs (without angle brackets) of the messages in
+// the IMAP folder a PST folder maps to. A missing folder yields an empty set.
+func (u *Uploader) MessageIDs(folderName string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	imapFolder := u.folders.Map(folderName)
+
+	// The client doesn't tell a NO [NONEXISTENT] to SELECT from any other
+	// failure, so the folder is looked up first
+	selectable, err := u.selectable(imapFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up folder %s: %w", imapFolder, err)
+	}
+	if !selectable {
+		// Folder doesn't exist (yet), so nothing from it has been uploaded
+		return ids, nil
+	}
+
+	mbox, err := u.client.Select(imapFolder, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select folder %s: %w", imapFolder, err)
+	}
+	if mbox.Messages == 0 {
+		return ids, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, mbox.Messages)
+
+	messages := make(chan *imap.Message, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- u.client.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope}, messages)
+	}()
+
+	for m := range messages {
+		if m.Envelope != nil && m.Envelope.MessageId != "" {
+			ids[strings.Trim(m.Envelope.MessageId, "<>")] = true
+		}
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch message IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// selectable reports whether an IMAP folder exists and can hold messages
+func (u *Uploader) selectable(folder string) (bool, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- u.client.List("", folder, mailboxes)
+	}()
+
+	found := false
+	for info := range mailboxes {
+		// INBOX is the one name that isn't case-sensitive
+		if info.Name != folder && !(strings.EqualFold(folder, "INBOX") && strings.EqualFold(info.Name, "INBOX")) {
+			continue
+		}
+		found = true
+		for _, attr := range info.Attributes {
+			if strings.EqualFold(attr, imap.NoSelectAttr) || strings.EqualFold(attr, `\NonExistent`) {
+				found = false
+			}
+		}
+	}
+	if err := <-done; err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
 // createFolder creates an IMAP folder if it doesn't exist
 func (u *Uploader) createFolder(folder string) error {
 	err := u.client.Create(folder)
@@ -95,21 +322,28 @@ func (u *Uploader) createFolder(folder string) error {
 	return nil
 }
 
-// mapToIMAPFolder converts a PST folder name to an IMAP folder name
+// MapFolder converts a PST folder name to the IMAP folder it is uploaded to
 // MXGuardian IMAP requires folders to be under INBOX namespace (INBOX.FolderName)
-func mapToIMAPFolder(folderName string) string {
+func MapFolder(folderName string) string {
+	return mapFolderParts(folderParts(folderName))
+}
+
+// folderParts splits a PST folder path into sanitized IMAP folder names,
+// without the PST root folder
+func folderParts(folderName string) []string {
 	// Clean up folder name
 	folder := strings.TrimSpace(folderName)
-
-	// Handle root folder
 	if folder == "" {
-		return "INBOX"
+		return nil
 	}
 
 	// Remove common PST root prefixes
-	folder = strings.TrimPrefix(folder, "Top of Personal Folders/")
-	folder = strings.TrimPrefix(folder, "Root - Mailbox/")
-	folder = strings.TrimPrefix(folder, "Root/")
+	for _, root := range []string{"Top of Personal Folders", "Root - Mailbox", "Root"} {
+		if folder == root {
+			return nil
+		}
+		folder = strings.TrimPrefix(folder, root+"/")
+	}
 
 	// Convert path separators to IMAP hierarchy separator (.)
 	folder = strings.ReplaceAll(folder, "/", ".")
@@ -128,7 +362,17 @@ func mapToIMAPFolder(folderName string) string {
 			cleanParts = append(cleanParts, part)
 		}
 	}
-	folder = strings.Join(cleanParts, ".")
+	return cleanParts
+}
+
+// mapFolderParts maps a folder path from folderParts to the IMAP folder,
+// putting well-known folders in their usual place and the rest under INBOX
+func mapFolderParts(parts []string) string {
+	// Handle root folder
+	if len(parts) == 0 {
+		return "INBOX"
+	}
+	folder := strings.Join(parts, ".")
 
 	// Map common PST folder names to IMAP
 	lowerFolder := strings.ToLower(folder)
@@ -203,8 +447,8 @@ func sanitizeFolderName(name string) string {
 }
 
 // TestConnection tests the IMAP connection without uploading
-func TestConnection(username, password string) error {
-	uploader, err := NewUploader(username, password)
+func TestConnection(ctx context.Context, server Server, username, password string) error {
+	uploader, err := NewUploader(ctx, server, username, password)
 	if err != nil {
 		return err
 	}
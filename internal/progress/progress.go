@@ -0,0 +1,134 @@
+// Package progress tracks import progress against pre-scanned totals and
+// derives percent complete, throughput and time remaining.
+// It is shared by the CLI and the GUI so both report the same figures.
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// minRateWindow is how long the tracker runs before it reports rates and ETA,
+// so the first few items don't produce wild estimates
+const minRateWindow = 2 * time.Second
+
+// Tracker accumulates progress. It is safe for concurrent use.
+type Tracker struct {
+	mu            sync.Mutex
+	totalMessages int
+	totalBytes    int64
+	doneMessages  int
+	doneBytes     int64
+
+	// Items passed over without work (e.g. already uploaded) count towards
+	// completion but not towards throughput
+	skippedMessages int
+	skippedBytes    int64
+
+	start time.Time
+}
+
+// Snapshot is the progress at a point in time
+type Snapshot struct {
+	Messages       int
+	TotalMessages  int
+	Bytes          int64
+	TotalBytes     int64
+	Percent        float64       // 0-100
+	MessagesPerSec float64       // Zero until enough time has passed
+	BytesPerSec    float64       // Zero until enough time has passed
+	ETA            time.Duration // Zero when unknown
+	Elapsed        time.Duration
+}
+
+// NewTracker creates a tracker for the given totals and starts its clock
+func NewTracker(totalMessages int, totalBytes int64) *Tracker {
+	return &Tracker{
+		totalMessages: totalMessages,
+		totalBytes:    totalBytes,
+		start:         time.Now(),
+	}
+}
+
+// Add records items that were processed
+func (t *Tracker) Add(messages int, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.doneMessages += messages
+	t.doneBytes += bytes
+}
+
+// Skip records items that were passed over without work
+func (t *Tracker) Skip(messages int, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.doneMessages += messages
+	t.doneBytes += bytes
+	t.skippedMessages += messages
+	t.skippedBytes += bytes
+}
+
+// Snapshot returns the current progress
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Snapshot{
+		Messages:      t.doneMessages,
+		TotalMessages: t.totalMessages,
+		Bytes:         t.doneBytes,
+		TotalBytes:    t.totalBytes,
+		Elapsed:       time.Since(t.start),
+	}
+
+	// Prefer bytes for percent and ETA: message sizes vary far more than counts
+	switch {
+	case t.totalBytes > 0:
+		s.Percent = percent(float64(t.doneBytes), float64(t.totalBytes))
+	case t.totalMessages > 0:
+		s.Percent = percent(float64(t.doneMessages), float64(t.totalMessages))
+	}
+
+	if s.Elapsed < minRateWindow {
+		return s
+	}
+
+	seconds := s.Elapsed.Seconds()
+	s.MessagesPerSec = float64(t.doneMessages-t.skippedMessages) / seconds
+	s.BytesPerSec = float64(t.doneBytes-t.skippedBytes) / seconds
+
+	switch {
+	case t.totalBytes > 0 && s.BytesPerSec > 0:
+		remaining := max(t.totalBytes-t.doneBytes, 0)
+		s.ETA = time.Duration(float64(remaining) / s.BytesPerSec * float64(time.Second))
+	case t.totalMessages > 0 && s.MessagesPerSec > 0:
+		remaining := max(t.totalMessages-t.doneMessages, 0)
+		s.ETA = time.Duration(float64(remaining) / s.MessagesPerSec * float64(time.Second))
+	}
+
+	return s
+}
+
+// Fraction returns completion between 0 and 1, for progress bars
+func (s Snapshot) Fraction() float64 {
+	return s.Percent / 100
+}
+
+// String formats the snapshot for display, e.g.
+// "42.0% (1200/2857 messages), 15.2 msg/s, 1.3 MB/s, ETA 3m5s"
+func (s Snapshot) String() string {
+	text := fmt.Sprintf("%.1f%% (%d/%d messages)", s.Percent, s.Messages, s.TotalMessages)
+	if s.MessagesPerSec > 0 {
+		text += fmt.Sprintf(", %.1f msg/s, %.1f MB/s", s.MessagesPerSec, s.BytesPerSec/(1024*1024))
+	}
+	if s.ETA > 0 {
+		text += ", ETA " + s.ETA.Round(time.Second).String()
+	}
+	return text
+}
+
+// percent returns done/total as a percentage capped at 100
+func percent(done, total float64) float64 {
+	return min(done/total*100, 100)
+}
@@ -0,0 +1,171 @@
+package pst
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// decodeCharset converts text in a MIME charset to UTF-8
+// Returns false if the charset is unknown.
+func decodeCharset(data []byte, charset string) (string, bool) {
+	if isUTF8Charset(charset) {
+		return string(data), true
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", false
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// encodeCharset converts UTF-8 text to a MIME charset, "" meaning US-ASCII
+// Returns false if the charset is unknown or can't represent the text.
+func encodeCharset(text, charset string) ([]byte, bool) {
+	if isUTF8Charset(charset) {
+		return []byte(text), true
+	}
+	if charset == "" || strings.EqualFold(charset, "us-ascii") {
+		for i := 0; i < len(text); i++ {
+			if text[i] > 127 {
+				return nil, false
+			}
+		}
+		return []byte(text), true
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, false
+	}
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return nil, false
+	}
+	return encoded, true
+}
+
+// isUTF8Charset reports whether a MIME charset is UTF-8
+func isUTF8Charset(charset string) bool {
+	return strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8")
+}
+
+// encodeTransportBody encodes the body of a single-part message to follow
+// its original transport headers, in the charset and transfer encoding they
+// declare
+// The HTML body is used when the headers declare text/html or there is no text
+// body, and the headers are changed to match. If the declared charset can't
+// represent the body, they are changed to declare UTF-8 instead.
+func encodeTransportBody(headers, bodyText, bodyHTML string) (string, []byte) {
+	mediaType, params := "text/plain", map[string]string{}
+	if contentType := headerValue(headers, "Content-Type"); contentType != "" {
+		if parsedType, parsedParams, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType, params = parsedType, parsedParams
+		}
+	}
+
+	declaredType := mediaType
+	text := bodyText
+	if bodyHTML != "" && (mediaType == "text/html" || bodyText == "") {
+		text, mediaType = bodyHTML, "text/html"
+	}
+
+	body, ok := encodeCharset(text, params["charset"])
+	if !ok {
+		params["charset"] = "utf-8"
+		body = []byte(text)
+	}
+	if !ok || mediaType != declaredType {
+		if headerValue(headers, "MIME-Version") == "" {
+			headers = replaceHeader(headers, "MIME-Version", "1.0")
+		}
+		headers = replaceHeader(headers, "Content-Type", mime.FormatMediaType(mediaType, params))
+	}
+
+	switch strings.ToLower(headerValue(headers, "Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64Lines(body)
+	case "quoted-printable":
+		var buf bytes.Buffer
+		qp := quotedprintable.NewWriter(&buf)
+		qp.Write(body)
+		qp.Close()
+		body = buf.Bytes()
+	}
+	return headers, body
+}
+
+// base64Lines encodes data as base64 in lines of 76 characters
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// headerFields splits a header block into its fields, each with any
+// continuation lines
+func headerFields(headers string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(headers, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+	return fields
+}
+
+// headerValue returns the unfolded value of the first header field with the
+// given name, or ""
+func headerValue(headers, name string) string {
+	for _, field := range headerFields(headers) {
+		key, value, ok := strings.Cut(field, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// replaceHeader removes every header field with the given name and adds it
+// again with value at the end
+// The header block returned ends with a single line break.
+func replaceHeader(headers, name, value string) string {
+	return removeHeader(headers, name) + name + ": " + value + "\r\n"
+}
+
+// removeHeader removes every header field with the given name
+// The header block returned ends with a single line break.
+func removeHeader(headers, name string) string {
+	var buf strings.Builder
+	for _, field := range headerFields(headers) {
+		key, _, ok := strings.Cut(field, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			continue
+		}
+		buf.WriteString(field)
+		if !strings.HasSuffix(field, "\n") {
+			buf.WriteString("\r\n")
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,195 @@
+package pst
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/mooijtech/go-pst/v6/pkg"
+	"github.com/mooijtech/go-pst/v6/pkg/properties"
+	"github.com/tinylib/msgp/msgp"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// Windows code page numbers with special meaning
+const (
+	codePageWindows1252 = 1252  // Western European, the default
+	codePageUTF8        = 65001 // No decoding needed
+)
+
+// Properties holding a message's code pages and its binary HTML body
+const (
+	propSubject          = 0x0037 // PidTagSubject
+	propHTML             = 0x1013 // PidTagHtml, as binary in the internet code page
+	propInternetCodePage = 0x3FDE // PidTagInternetCodepage, the code page of the HTML body
+	propMessageCodePage  = 0x3FFD // PidTagMessageCodepage, the code page of PT_STRING8 properties
+)
+
+// metaCharset matches the charset declared by an HTML <meta> tag
+var metaCharset = regexp.MustCompile(`(?i)(<meta\b[^>]*?charset\s*=\s*["']?)([\w.:-]+)`)
+
+// codePages maps Windows code page numbers to their encodings
+var codePages = map[int]encoding.Encoding{
+	437:   charmap.CodePage437,
+	850:   charmap.CodePage850,
+	852:   charmap.CodePage852,
+	866:   charmap.CodePage866,
+	874:   charmap.Windows874,
+	932:   japanese.ShiftJIS,
+	936:   simplifiedchinese.GBK,
+	949:   korean.EUCKR,
+	950:   traditionalchinese.Big5,
+	1250:  charmap.Windows1250,
+	1251:  charmap.Windows1251,
+	1252:  charmap.Windows1252,
+	1253:  charmap.Windows1253,
+	1254:  charmap.Windows1254,
+	1255:  charmap.Windows1255,
+	1256:  charmap.Windows1256,
+	1257:  charmap.Windows1257,
+	1258:  charmap.Windows1258,
+	10000: charmap.Macintosh,
+	20866: charmap.KOI8R,
+	21866: charmap.KOI8U,
+	28591: charmap.ISO8859_1,
+	28592: charmap.ISO8859_2,
+	28595: charmap.ISO8859_5,
+	28597: charmap.ISO8859_7,
+	28599: charmap.ISO8859_9,
+	28605: charmap.ISO8859_15,
+	50220: japanese.ISO2022JP,
+	51932: japanese.EUCJP,
+	54936: simplifiedchinese.GB18030,
+}
+
+// decodeCodePage converts text in a Windows code page to UTF-8
+// Unknown code pages are read as Windows-1252.
+func decodeCodePage(data []byte, codePage int) string {
+	if codePage == codePageUTF8 {
+		return string(data)
+	}
+	enc, ok := codePages[codePage]
+	if !ok {
+		enc = codePages[codePageWindows1252]
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// stringCodePage returns the code page of a message's PT_STRING8 properties
+func stringCodePage(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor) int {
+	if codePage := readInt32Property(propContext, localDescriptors, propMessageCodePage); codePage > 0 {
+		return int(codePage)
+	}
+	if codePage := readInt32Property(propContext, localDescriptors, propInternetCodePage); codePage > 0 {
+		return int(codePage)
+	}
+	return codePageWindows1252
+}
+
+// populateProperties populates decodable from a message's properties
+// go-pst only fills string fields from PT_UNICODE properties, so those stored
+// as PT_STRING8, as in ANSI PSTs, are then decoded from the message's code page.
+func populateProperties(msg *pst.Message, decodable msgp.Decodable) error {
+	if err := msg.PropertyContext.Populate(decodable, msg.LocalDescriptors); err != nil {
+		return err
+	}
+
+	// Each value keyed as its PT_UNICODE property, which the fields are tagged with
+	var keys, values []string
+	codePage := -1
+	for _, property := range msg.PropertyContext.Properties {
+		if property.Type != pst.PropertyTypeString8 {
+			continue
+		}
+		data := bytes.TrimRight(readBinaryProperty(msg.PropertyContext, msg.LocalDescriptors, property.ID), "\x00")
+		if property.ID == propSubject && len(data) >= 2 && data[0] == 0x01 {
+			// A subject may start with 0x01 and the length of its prefix ("RE: ")
+			data = data[2:]
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if codePage < 0 {
+			codePage = stringCodePage(msg.PropertyContext, msg.LocalDescriptors)
+		}
+		keys = append(keys, fmt.Sprintf("%d%d", property.ID, pst.PropertyTypeString))
+		values = append(values, decodeCodePage(data, codePage))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	writer := msgp.NewWriter(&buf)
+	writer.WriteMapHeader(uint32(len(keys)))
+	for i, key := range keys {
+		writer.WriteString(key)
+		writer.WriteString(values[i])
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := decodable.DecodeMsg(msgp.NewReader(&buf)); err != nil {
+		return fmt.Errorf("failed to decode 8-bit string properties: %w", err)
+	}
+	return nil
+}
+
+// readHTMLBody reads the HTML body Outlook stores as binary, converted to
+// UTF-8, or "" if there is none
+// The bytes are in the internet code page, or else the charset the HTML
+// declares, which is changed to utf-8 to match.
+func readHTMLBody(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor) string {
+	data := bytes.TrimRight(readBinaryProperty(propContext, localDescriptors, propHTML), "\x00")
+	if len(data) == 0 {
+		return ""
+	}
+
+	var html string
+	if codePage := readInt32Property(propContext, localDescriptors, propInternetCodePage); codePage > 0 {
+		html = decodeCodePage(data, int(codePage))
+	} else if match := metaCharset.FindSubmatch(data); match != nil {
+		decoded, ok := decodeCharset(data, string(match[2]))
+		if !ok {
+			decoded = decodeCodePage(data, stringCodePage(propContext, localDescriptors))
+		}
+		html = decoded
+	} else {
+		html = decodeCodePage(data, stringCodePage(propContext, localDescriptors))
+	}
+	return metaCharset.ReplaceAllString(html, "${1}utf-8")
+}
+
+// setANSIItemType gives an item of an ANSI PST the properties type go-pst
+// gives Unicode items by message class
+// go-pst can't read an 8-bit message class, so it treats every such item as
+// an email message.
+func setANSIItemType(msg *pst.Message) {
+	property, err := msg.PropertyContext.GetPropertyByID(propMessageClass)
+	if err != nil || property.Type != pst.PropertyTypeString8 {
+		return
+	}
+	switch readStringProperty(msg.PropertyContext, msg.LocalDescriptors, propMessageClass) {
+	case "IPM.Appointment", "IPM.Schedule.Meeting", "IPM.Schedule.Meeting.Request", "IPM.OLE.CLASS.{00061055-0000-0000-C000-000000000046}":
+		msg.Properties = &properties.Appointment{}
+	case "IPM.Contact", "IPM.AbchPerson":
+		msg.Properties = &properties.Contact{}
+	case "IPM.Task":
+		msg.Properties = &properties.Task{}
+	case "IPM.Activity":
+		msg.Properties = &properties.Journal{}
+	case "IPM.Post.Rss":
+		msg.Properties = &properties.RSS{}
+	case "IPM.DistList":
+		msg.Properties = &properties.AddressBook{}
+	}
+}
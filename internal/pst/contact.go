@@ -1,9 +1,12 @@
 package pst
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
+	"strings"
 	"time"
 	"unicode/utf16"
 
@@ -17,6 +20,11 @@ type Contact struct {
 	UID  string     // Unique ID for CardDAV
 	Name string     // Display name for logging
 	Card vcard.Card // vCard data
+
+	// The contacts folder the contact came from, by full path, and whether
+	// it is the PST's main Contacts folder rather than another address book
+	Folder        string
+	DefaultFolder bool
 }
 
 // ContactCallback is called for each contact as it's read from the PST
@@ -31,30 +39,69 @@ const (
 	pidLidEmail3EmailAddress = 0x80A3
 )
 
+// Other named properties in PSETID_Address read for the vCard
+const (
+	pidLidEmail1DisplayName       = 0x8080 // As shown in Outlook, e.g. "Jane Doe (jane@example.com)"
+	pidLidEmail2DisplayName       = 0x8090
+	pidLidEmail3DisplayName       = 0x80A0
+	pidLidInstantMessagingAddress = 0x8062
+	pidLidHTML                    = 0x802B // The contact's "Web page address"
+)
+
+// contactEmail is one of a contact's three email addresses
+type contactEmail struct {
+	address     string
+	displayName string
+}
+
+// namedContactProps holds the contact properties read by name, which the
+// go-pst Contact methods don't return
+type namedContactProps struct {
+	emails  []contactEmail
+	im      string
+	webPage string
+}
+
 // readNamedProperty reads a named property from the PropertyContext using NameToIDMap
 // Returns empty string if property not found or error occurs
 func readNamedProperty(pstFile *pst.File, propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, namedPropID int) string {
+	data := readNamedBinary(pstFile, propContext, localDescriptors, namedPropID)
+	if data == nil {
+		return ""
+	}
+
+	// ANSI PSTs store 8-bit strings in the message's code page
+	mappedID, _ := pstFile.NameToIDMap.GetPropertyID(namedPropID, pst.PropertySetAddress)
+	if property, err := propContext.GetPropertyByID(uint16(mappedID)); err == nil && property.Type == pst.PropertyTypeString8 {
+		return decodeCodePage(bytes.TrimRight(data, "\x00"), stringCodePage(propContext, localDescriptors))
+	}
+
+	// Decode UTF-16LE string
+	return decodeUTF16LE(data)
+}
+
+// readNamedBinary reads the raw value of a named property in PSETID_Address
+// Returns nil if property not found or error occurs
+func readNamedBinary(pstFile *pst.File, propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, namedPropID int) []byte {
 	// Map the named property ID to actual property ID
 	mappedID, err := pstFile.NameToIDMap.GetPropertyID(namedPropID, pst.PropertySetAddress)
 	if err != nil {
-		return ""
+		return nil
 	}
 
 	// Read the property value
 	propReader, err := propContext.GetPropertyReader(uint16(mappedID), localDescriptors)
-	if err != nil {
-		return ""
+	if err != nil || propReader.HeapOnNodeReader == nil {
+		return nil
 	}
 
 	// Read raw data
 	data := make([]byte, propReader.Size())
 	_, err = propReader.ReadAt(data, 0)
 	if err != nil {
-		return ""
+		return nil
 	}
-
-	// Decode UTF-16LE string
-	return decodeUTF16LE(data)
+	return data
 }
 
 // decodeUTF16LE decodes a UTF-16 little-endian byte slice to a Go string
@@ -119,8 +166,28 @@ func buildContact(pstFile *pst.File, propContext *pst.PropertyContext, localDesc
 	// Generate UID from content hash for deduplication
 	uid := generateContactUID(displayName, email1, email2, email3)
 
+	named := namedContactProps{
+		im:      readNamedProperty(pstFile, propContext, localDescriptors, pidLidInstantMessagingAddress),
+		webPage: readNamedProperty(pstFile, propContext, localDescriptors, pidLidHTML),
+	}
+	for _, email := range []struct {
+		address string
+		nameID  int
+	}{
+		{email1, pidLidEmail1DisplayName},
+		{email2, pidLidEmail2DisplayName},
+		{email3, pidLidEmail3DisplayName},
+	} {
+		if email.address != "" {
+			named.emails = append(named.emails, contactEmail{
+				address:     email.address,
+				displayName: readNamedProperty(pstFile, propContext, localDescriptors, email.nameID),
+			})
+		}
+	}
+
 	// Build vCard
-	card := buildVCard(props, msgProps, displayName, givenName, surname, uid, email1, email2, email3)
+	card := buildVCard(props, msgProps, named, displayName, givenName, surname, uid)
 
 	return &Contact{
 		UID:  uid,
@@ -136,15 +203,28 @@ func generateContactUID(name, email1, email2, email3 string) string {
 	return fmt.Sprintf("%x", hash[:8])
 }
 
+// uidNamespace is the namespace of the UUIDs cardUID derives from contact IDs
+var uidNamespace = [16]byte{0x5c, 0x3e, 0x0a, 0x91, 0x7d, 0x24, 0x4f, 0x6b, 0x9e, 0x58, 0x21, 0xc7, 0x4b, 0x0d, 0xe3, 0x6a}
+
+// cardUID returns the UID written in the card of a contact or group: a
+// urn:uuid: URI with a name-based UUID (RFC 4122 version 5) of its ID, so
+// it is the same on every import and MEMBER values can be derived from it
+func cardUID(uid string) string {
+	hash := sha1.Sum(append(uidNamespace[:], uid...))
+	hash[6] = hash[6]&0x0f | 0x50 // Version 5
+	hash[8] = hash[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+}
+
 // buildVCard constructs a vcard.Card from contact properties
-func buildVCard(props *properties.Contact, msgProps *properties.Message, displayName, givenName, surname, uid, email1, email2, email3 string) vcard.Card {
+func buildVCard(props *properties.Contact, msgProps *properties.Message, named namedContactProps, displayName, givenName, surname, uid string) vcard.Card {
 	card := make(vcard.Card)
 
 	// VERSION is required
 	card.SetValue(vcard.FieldVersion, "3.0")
 
 	// UID
-	card.SetValue(vcard.FieldUID, uid+"@pst-import")
+	card.SetValue(vcard.FieldUID, cardUID(uid))
 
 	// FN (formatted name) - required
 	card.SetValue(vcard.FieldFormattedName, displayName)
@@ -152,28 +232,25 @@ func buildVCard(props *properties.Contact, msgProps *properties.Message, display
 	// N (structured name)
 	// Format: Family;Given;Additional;Prefix;Suffix
 	prefix := props.GetDisplayNamePrefix()
+	var middleName string
+	if msgProps != nil {
+		middleName = msgProps.GetMiddleName()
+	}
 	card.Set(vcard.FieldName, &vcard.Field{
-		Value: surname + ";" + givenName + ";;" + prefix + ";",
+		Value: surname + ";" + givenName + ";" + middleName + ";" + prefix + ";" + props.GetGeneration(),
 	})
 
-	// Email addresses (passed in as parameters, read via named properties)
-	if email1 != "" {
-		card.Add(vcard.FieldEmail, &vcard.Field{
-			Value:  email1,
-			Params: vcard.Params{vcard.ParamType: {"INTERNET"}},
-		})
-	}
-	if email2 != "" {
-		card.Add(vcard.FieldEmail, &vcard.Field{
-			Value:  email2,
+	// Email addresses (read via named properties), with the name Outlook
+	// shows for each when it isn't just the address
+	for _, email := range named.emails {
+		field := &vcard.Field{
+			Value:  email.address,
 			Params: vcard.Params{vcard.ParamType: {"INTERNET"}},
-		})
-	}
-	if email3 != "" {
-		card.Add(vcard.FieldEmail, &vcard.Field{
-			Value:  email3,
-			Params: vcard.Params{vcard.ParamType: {"INTERNET"}},
-		})
+		}
+		if name := email.displayName; name != "" && name != email.address && name != displayName+" ("+email.address+")" {
+			field.Params.Set("X-DISPLAYNAME", name)
+		}
+		card.Add(vcard.FieldEmail, field)
 	}
 
 	// Phone numbers from Contact properties
@@ -220,6 +297,26 @@ func buildVCard(props *properties.Contact, msgProps *properties.Message, display
 		})
 	}
 
+	// Fax numbers
+	if fax := props.GetPrimaryFaxNumber(); fax != "" {
+		card.Add(vcard.FieldTelephone, &vcard.Field{
+			Value:  fax,
+			Params: vcard.Params{vcard.ParamType: {"FAX"}},
+		})
+	}
+	if fax := props.GetBusinessFaxNumber(); fax != "" {
+		card.Add(vcard.FieldTelephone, &vcard.Field{
+			Value:  fax,
+			Params: vcard.Params{vcard.ParamType: {"WORK", "FAX"}},
+		})
+	}
+	if fax := props.GetHomeFaxNumber(); fax != "" {
+		card.Add(vcard.FieldTelephone, &vcard.Field{
+			Value:  fax,
+			Params: vcard.Params{vcard.ParamType: {"HOME", "FAX"}},
+		})
+	}
+
 	// Phone numbers from Message properties (mobile, pager, other)
 	if msgProps != nil {
 		if phone := msgProps.GetMobileTelephoneNumber(); phone != "" {
@@ -255,40 +352,106 @@ func buildVCard(props *properties.Contact, msgProps *properties.Message, display
 		card.SetValue(vcard.FieldTitle, title)
 	}
 
-	// Work address
+	// Work, home and other addresses
 	// ADR format: PO Box;Extended;Street;City;Region;Postal Code;Country
-	workStreet := props.GetWorkAddressStreet()
-	workCity := props.GetWorkAddressCity()
-	workState := props.GetWorkAddressState()
-	workPostal := props.GetWorkAddressPostalCode()
-	workCountry := props.GetWorkAddressCountry()
-	if workStreet != "" || workCity != "" || workState != "" || workPostal != "" || workCountry != "" {
-		card.Add(vcard.FieldAddress, &vcard.Field{
-			Value:  ";;" + workStreet + ";" + workCity + ";" + workState + ";" + workPostal + ";" + workCountry,
+	addAddress(card, "WORK", props.GetWorkAddressPostOfficeBox(), props.GetWorkAddressStreet(), props.GetWorkAddressCity(),
+		props.GetWorkAddressState(), props.GetWorkAddressPostalCode(), props.GetWorkAddressCountry())
+	addAddress(card, "HOME", props.GetHomeAddressPostOfficeBox(), props.GetHomeAddressStreet(), props.GetHomeAddressCity(),
+		props.GetHomeAddressStateOrProvince(), props.GetHomeAddressPostalCode(), props.GetHomeAddressCountry())
+	if msgProps != nil {
+		addAddress(card, "OTHER", msgProps.GetOtherAddressPostOfficeBox(), msgProps.GetOtherAddressStreet(), msgProps.GetOtherAddressCity(),
+			msgProps.GetOtherAddressStateOrProvince(), msgProps.GetOtherAddressPostalCode(), msgProps.GetOtherAddressCountry())
+	}
+
+	// Birthday and anniversary
+	// ANNIVERSARY is vCard 4.0; clients reading 3.0 look for X-ANNIVERSARY.
+	if birthday := contactDate(props.GetBirthday()); birthday != "" {
+		card.SetValue(vcard.FieldBirthday, birthday)
+	}
+	if anniversary := contactDate(props.GetWeddingAnniversary()); anniversary != "" {
+		card.SetValue(vcard.FieldAnniversary, anniversary)
+		card.SetValue("X-ANNIVERSARY", anniversary)
+	}
+
+	// Web pages: business, personal, and the "Web page address"
+	if url := props.GetBusinessHomePage(); url != "" {
+		card.Add(vcard.FieldURL, &vcard.Field{
+			Value:  url,
 			Params: vcard.Params{vcard.ParamType: {"WORK"}},
 		})
 	}
-
-	// Home address
-	homeStreet := props.GetHomeAddressStreet()
-	homeCity := props.GetHomeAddressCity()
-	homeState := props.GetHomeAddressStateOrProvince()
-	homePostal := props.GetHomeAddressPostalCode()
-	homeCountry := props.GetHomeAddressCountry()
-	if homeStreet != "" || homeCity != "" || homeState != "" || homePostal != "" || homeCountry != "" {
-		card.Add(vcard.FieldAddress, &vcard.Field{
-			Value:  ";;" + homeStreet + ";" + homeCity + ";" + homeState + ";" + homePostal + ";" + homeCountry,
+	if url := props.GetPersonalHomePage(); url != "" {
+		card.Add(vcard.FieldURL, &vcard.Field{
+			Value:  url,
 			Params: vcard.Params{vcard.ParamType: {"HOME"}},
 		})
 	}
+	if url := named.webPage; url != "" && url != props.GetBusinessHomePage() && url != props.GetPersonalHomePage() {
+		card.Add(vcard.FieldURL, &vcard.Field{Value: url})
+	}
+
+	// Instant messaging address, as a URI (RFC 4770)
+	if im := strings.TrimSpace(named.im); im != "" {
+		if !strings.Contains(im, ":") {
+			im = "im:" + im
+		}
+		card.SetValue(vcard.FieldIMPP, im)
+	}
 
-	// Birthday
-	if birthday := props.GetBirthdayLocal(); birthday > 0 {
-		t := time.Unix(birthday, 0)
-		if t.Year() >= 1900 && t.Year() <= 2100 {
-			card.SetValue(vcard.FieldBirthday, t.Format("2006-01-02"))
+	// Nickname and the people around the contact
+	if msgProps != nil {
+		if nickname := msgProps.GetNickname(); nickname != "" {
+			card.SetValue(vcard.FieldNickname, nickname)
+		}
+		if assistant := msgProps.GetAssistant(); assistant != "" {
+			card.SetValue("X-ASSISTANT", assistant)
+		}
+		if phone := msgProps.GetAssistantTelephoneNumber(); phone != "" {
+			card.Add(vcard.FieldTelephone, &vcard.Field{
+				Value:  phone,
+				Params: vcard.Params{vcard.ParamType: {"X-ASSISTANT"}},
+			})
+		}
+		if manager := msgProps.GetManagerName(); manager != "" {
+			card.SetValue("X-MANAGER", manager)
+		}
+	}
+	if spouse := props.GetSpouseName(); spouse != "" {
+		card.SetValue("X-SPOUSE", spouse)
+	}
+
+	// Notes from the contact's body
+	if msgProps != nil {
+		if note := strings.TrimSpace(msgProps.GetBody()); note != "" {
+			card.SetValue(vcard.FieldNote, strings.ReplaceAll(note, "\r\n", "\n"))
 		}
 	}
 
 	return card
 }
+
+// addAddress adds an ADR field of a type unless every part is empty
+func addAddress(card vcard.Card, addressType, poBox, street, city, region, postalCode, country string) {
+	if poBox == "" && street == "" && city == "" && region == "" && postalCode == "" && country == "" {
+		return
+	}
+	card.Add(vcard.FieldAddress, &vcard.Field{
+		Value:  poBox + ";;" + street + ";" + city + ";" + region + ";" + postalCode + ";" + country,
+		Params: vcard.Params{vcard.ParamType: {addressType}},
+	})
+}
+
+// contactDate formats a birthday or anniversary as YYYY-MM-DD, or returns ""
+// for no date
+// Outlook stores these as midnight local time in UTC, which can fall on the
+// evening before, so the date is rounded to the nearest day.
+func contactDate(nanos int64) string {
+	if nanos == 0 {
+		return ""
+	}
+	t := time.Unix(0, nanos).UTC().Add(12 * time.Hour)
+	if t.Year() < 1900 || t.Year() > 2100 {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,198 @@
+package pst
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-vcard"
+	"github.com/mooijtech/go-pst/v6/pkg/properties"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// fixtureCard builds the card of a contact with every field buildVCard reads
+func fixtureCard() vcard.Card {
+	props := &properties.Contact{
+		GivenName:          ptr("Jane"),
+		Surname:            ptr("Doe"),
+		DisplayNamePrefix:  ptr("Dr."),
+		Generation:         ptr("Jr."),
+		PrimaryFaxNumber:   ptr("+1 555 0100"),
+		BusinessFaxNumber:  ptr("+1 555 0101"),
+		HomeFaxNumber:      ptr("+1 555 0102"),
+		BusinessHomePage:   ptr("https://work.example.com"),
+		PersonalHomePage:   ptr("https://home.example.com"),
+		SpouseName:         ptr("John Doe"),
+		WeddingAnniversary: ptr(time.Date(1990, 6, 14, 22, 0, 0, 0, time.UTC).UnixNano()), // Midnight in UTC+2
+	}
+	msgProps := &properties.Message{
+		MiddleName:             ptr("Q"),
+		Nickname:               ptr("JD"),
+		Assistant:              ptr("Sam Smith"),
+		ManagerName:            ptr("Pat Jones"),
+		OtherAddressStreet:     ptr("1 Other St"),
+		OtherAddressCity:       ptr("Springfield"),
+		OtherAddressPostalCode: ptr("12345"),
+		OtherAddressCountry:    ptr("USA"),
+		Body:                   ptr("Line one\r\nLine two\r\n"),
+	}
+	named := namedContactProps{
+		emails: []contactEmail{
+			{address: "jane@example.com", displayName: "Jane Doe (jane@example.com)"},
+			{address: "jd@work.example.com", displayName: "Jane at work"},
+			{address: "jane@home.example.com", displayName: "jane@home.example.com"},
+		},
+		im:      "jane.doe",
+		webPage: "https://blog.example.com",
+	}
+	return buildVCard(props, msgProps, named, "Jane Doe", "Jane", "Doe", "0123456789abcdef")
+}
+
+// findField returns the field of a property with a value, or nil
+func findField(card vcard.Card, name, value string) *vcard.Field {
+	for _, field := range card[name] {
+		if field.Value == value {
+			return field
+		}
+	}
+	return nil
+}
+
+// checkTypes fails the test unless field is there with exactly the TYPE values
+func checkTypes(t *testing.T, name, value string, field *vcard.Field, types ...string) {
+	t.Helper()
+	if field == nil {
+		t.Errorf("%s %q missing", name, value)
+		return
+	}
+	if got := field.Params[vcard.ParamType]; !slices.Equal(got, types) {
+		t.Errorf("%s %q has TYPE %v, want %v", name, value, got, types)
+	}
+}
+
+func TestBuildVCardNotes(t *testing.T) {
+	card := fixtureCard()
+	if got, want := card.Value(vcard.FieldNote), "Line one\nLine two"; got != want {
+		t.Errorf("NOTE = %q, want %q", got, want)
+	}
+}
+
+func TestBuildVCardURLs(t *testing.T) {
+	card := fixtureCard()
+	if got := len(card[vcard.FieldURL]); got != 3 {
+		t.Errorf("got %d URLs, want 3", got)
+	}
+	for _, url := range []struct {
+		value string
+		types []string
+	}{
+		{"https://work.example.com", []string{"WORK"}},
+		{"https://home.example.com", []string{"HOME"}},
+		{"https://blog.example.com", nil},
+	} {
+		checkTypes(t, vcard.FieldURL, url.value, findField(card, vcard.FieldURL, url.value), url.types...)
+	}
+}
+
+func TestBuildVCardWebPageNotRepeated(t *testing.T) {
+	props := &properties.Contact{BusinessHomePage: ptr("https://work.example.com")}
+	named := namedContactProps{webPage: "https://work.example.com"}
+	card := buildVCard(props, nil, named, "Jane Doe", "Jane", "Doe", "0123456789abcdef")
+	if got := len(card[vcard.FieldURL]); got != 1 {
+		t.Errorf("got %d URLs, want 1", got)
+	}
+}
+
+func TestBuildVCardIMPP(t *testing.T) {
+	for _, test := range []struct {
+		im, want string
+	}{
+		{"jane.doe", "im:jane.doe"},
+		{" xmpp:jane@example.com ", "xmpp:jane@example.com"},
+	} {
+		named := namedContactProps{im: test.im}
+		card := buildVCard(&properties.Contact{}, nil, named, "Jane Doe", "Jane", "Doe", "0123456789abcdef")
+		if got := card.Value(vcard.FieldIMPP); got != test.want {
+			t.Errorf("IMPP for %q = %q, want %q", test.im, got, test.want)
+		}
+	}
+}
+
+func TestBuildVCardNickname(t *testing.T) {
+	card := fixtureCard()
+	if got, want := card.Value(vcard.FieldNickname), "JD"; got != want {
+		t.Errorf("NICKNAME = %q, want %q", got, want)
+	}
+}
+
+func TestBuildVCardName(t *testing.T) {
+	card := fixtureCard()
+	if got, want := card.Value(vcard.FieldName), "Doe;Jane;Q;Dr.;Jr."; got != want {
+		t.Errorf("N = %q, want %q", got, want)
+	}
+}
+
+func TestBuildVCardAnniversary(t *testing.T) {
+	card := fixtureCard()
+	for _, name := range []string{vcard.FieldAnniversary, "X-ANNIVERSARY"} {
+		if got, want := card.Value(name), "1990-06-15"; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBuildVCardFaxNumbers(t *testing.T) {
+	card := fixtureCard()
+	for _, fax := range []struct {
+		value string
+		types []string
+	}{
+		{"+1 555 0100", []string{"FAX"}},
+		{"+1 555 0101", []string{"WORK", "FAX"}},
+		{"+1 555 0102", []string{"HOME", "FAX"}},
+	} {
+		checkTypes(t, vcard.FieldTelephone, fax.value, findField(card, vcard.FieldTelephone, fax.value), fax.types...)
+	}
+}
+
+func TestBuildVCardOtherAddress(t *testing.T) {
+	card := fixtureCard()
+	value := ";;1 Other St;Springfield;;12345;USA"
+	checkTypes(t, vcard.FieldAddress, value, findField(card, vcard.FieldAddress, value), "OTHER")
+}
+
+func TestBuildVCardRelatedPeople(t *testing.T) {
+	card := fixtureCard()
+	for name, want := range map[string]string{
+		"X-ASSISTANT": "Sam Smith",
+		"X-MANAGER":   "Pat Jones",
+		"X-SPOUSE":    "John Doe",
+	} {
+		if got := card.Value(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBuildVCardEmailDisplayNames(t *testing.T) {
+	card := fixtureCard()
+	for _, email := range []struct {
+		address, displayName string
+	}{
+		{"jane@example.com", ""}, // The name Outlook makes up
+		{"jd@work.example.com", "Jane at work"},
+		{"jane@home.example.com", ""}, // Just the address
+	} {
+		field := findField(card, vcard.FieldEmail, email.address)
+		if field == nil {
+			t.Errorf("EMAIL %q missing", email.address)
+			continue
+		}
+		if got := field.Params.Get("X-DISPLAYNAME"); got != email.displayName {
+			t.Errorf("X-DISPLAYNAME of %q = %q, want %q", email.address, got, email.displayName)
+		}
+	}
+}
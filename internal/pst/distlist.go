@@ -0,0 +1,214 @@
+package pst
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+	"github.com/mooijtech/go-pst/v6/pkg"
+	"github.com/mooijtech/go-pst/v6/pkg/properties"
+)
+
+// How distribution lists are written as vCards
+const (
+	GroupsKind  = "kind"  // vCard 4.0 KIND:group with MEMBER properties (RFC 6350), whatever the version of the contacts
+	GroupsApple = "apple" // X-ADDRESSBOOKSERVER-KIND and -MEMBER, as Apple Contacts uses
+)
+
+// Named properties of a distribution list in PSETID_Address
+// See MS-OXOCNTC section 2.2.2
+const (
+	pidLidDistributionListName          = 0x8053
+	pidLidDistributionListOneOffMembers = 0x8054 // One-off EntryID of every member
+	pidLidDistributionListMembers       = 0x8055 // EntryID of every member, wrapped for contacts
+)
+
+// Provider UIDs identifying the kind of an EntryID (MS-OXCDATA section 2.2.5)
+var (
+	oneOffProviderUID  = []byte{0x81, 0x2B, 0x1F, 0xA4, 0xBE, 0xA3, 0x10, 0x19, 0x9D, 0x6E, 0x00, 0xDD, 0x01, 0x0F, 0x54, 0x02}
+	wrappedProviderUID = []byte{0xC0, 0x91, 0xAD, 0xD3, 0x51, 0x9D, 0xCF, 0x11, 0xA4, 0xA9, 0x00, 0xAA, 0x00, 0x47, 0xFA, 0xA4}
+)
+
+// Wrapped EntryID types for members that are items in the PST
+const (
+	wrappedContact  = 0x03
+	wrappedDistList = 0x04
+)
+
+// distList is a distribution list read from the PST, kept until every
+// contact has been read so linked members can be resolved to their UIDs
+type distList struct {
+	name      string
+	folder    string
+	isDefault bool
+	members   []distListMember
+}
+
+// distListMember is one member of a distribution list: a contact or list in
+// the PST, identified by its node, or a one-off email address
+// Linked members carry the address too, for when the item wasn't imported.
+type distListMember struct {
+	name  string
+	email string
+	item  pst.Identifier // 0 for a one-off member
+}
+
+// SetGroupStyle chooses how distribution lists are written as vCards:
+// GroupsKind (the default) or GroupsApple
+func (e *Extractor) SetGroupStyle(style string) error {
+	switch style {
+	case "":
+		e.groupStyle = GroupsKind
+	case GroupsKind, GroupsApple:
+		e.groupStyle = style
+	default:
+		return fmt.Errorf("unknown group style %q (use %s or %s)", style, GroupsKind, GroupsApple)
+	}
+	return nil
+}
+
+// readDistList reads a distribution list's name and members, or returns nil
+// if it has no name
+func readDistList(pstFile *pst.File, msg *pst.Message, msgProps *properties.Message) *distList {
+	name := readNamedProperty(pstFile, msg.PropertyContext, msg.LocalDescriptors, pidLidDistributionListName)
+	if name == "" {
+		name = msgProps.GetSubject()
+	}
+	if name == "" {
+		return nil
+	}
+
+	members := multiValueBinary(readNamedBinary(pstFile, msg.PropertyContext, msg.LocalDescriptors, pidLidDistributionListMembers))
+	oneOffs := multiValueBinary(readNamedBinary(pstFile, msg.PropertyContext, msg.LocalDescriptors, pidLidDistributionListOneOffMembers))
+
+	list := &distList{name: name}
+	for i := 0; i < max(len(members), len(oneOffs)); i++ {
+		var member distListMember
+		if i < len(oneOffs) {
+			member.name, member.email = parseOneOffEntryID(oneOffs[i])
+		}
+		if i < len(members) {
+			if item := parseWrappedEntryID(members[i]); item != 0 {
+				member.item = item
+			} else if memberName, email := parseOneOffEntryID(members[i]); email != "" {
+				member.name, member.email = memberName, email
+			}
+		}
+		if member.item != 0 || member.email != "" {
+			list.members = append(list.members, member)
+		}
+	}
+	return list
+}
+
+// buildGroup converts a distribution list to a group Contact
+// uids maps the PST items read as contacts, and the other lists, to their
+// UIDs; a linked member that isn't there is written by email address instead.
+// KIND and MEMBER only exist in vCard 4.0, so GroupsKind cards are 4.0.
+func buildGroup(list *distList, uids map[pst.Identifier]string, style string) *Contact {
+	uid := groupUID(list.folder, list.name)
+
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, VCard30)
+	if style == GroupsKind {
+		card.SetValue(vcard.FieldVersion, VCard40)
+	}
+	card.SetValue(vcard.FieldUID, cardUID(uid))
+	card.SetValue(vcard.FieldFormattedName, list.name)
+	card.SetValue(vcard.FieldName, list.name+";;;;")
+
+	kindField, memberField := vcard.FieldKind, vcard.FieldMember
+	if style == GroupsApple {
+		kindField, memberField = "X-ADDRESSBOOKSERVER-KIND", "X-ADDRESSBOOKSERVER-MEMBER"
+	}
+	card.SetValue(kindField, string(vcard.KindGroup))
+
+	seen := make(map[string]bool)
+	for _, member := range list.members {
+		var value string
+		if memberUID, ok := uids[member.item]; ok {
+			value = cardUID(memberUID)
+		} else if member.email != "" {
+			value = "mailto:" + member.email
+		} else {
+			continue
+		}
+		if !seen[value] {
+			seen[value] = true
+			card.AddValue(memberField, value)
+		}
+	}
+
+	return &Contact{
+		UID:           uid,
+		Name:          list.name,
+		Card:          card,
+		Folder:        list.folder,
+		DefaultFolder: list.isDefault,
+	}
+}
+
+// groupUID creates a unique ID for a distribution list from its folder and
+// name, kept apart from the contact UIDs
+func groupUID(folder, name string) string {
+	hash := sha256.Sum256([]byte("group|" + folder + "|" + name))
+	return fmt.Sprintf("%x", hash[:8])
+}
+
+// parseOneOffEntryID returns the display name and email address from a
+// one-off EntryID, or "" for anything else or an address that isn't SMTP
+func parseOneOffEntryID(entryID []byte) (name, email string) {
+	if len(entryID) < 24 || !bytes.Equal(entryID[4:20], oneOffProviderUID) {
+		return "", ""
+	}
+	flags := binary.LittleEndian.Uint16(entryID[22:])
+	fields := entryID[24:]
+
+	var values []string
+	for i := 0; i < 3 && len(fields) > 0; i++ {
+		var value string
+		if flags&0x8000 != 0 { // MAPI_UNICODE
+			end := 0
+			for end+1 < len(fields) && (fields[end] != 0 || fields[end+1] != 0) {
+				end += 2
+			}
+			value = decodeUTF16LE(fields[:end])
+			fields = fields[min(end+2, len(fields)):]
+		} else {
+			end := bytes.IndexByte(fields, 0)
+			if end < 0 {
+				end = len(fields)
+			}
+			value = string(fields[:end])
+			fields = fields[min(end+1, len(fields)):]
+		}
+		values = append(values, value)
+	}
+	if len(values) < 3 {
+		return "", ""
+	}
+	name, addressType, email := values[0], values[1], values[2]
+	if !strings.EqualFold(addressType, "SMTP") && !strings.Contains(email, "@") {
+		return name, ""
+	}
+	return name, email
+}
+
+// parseWrappedEntryID returns the node of the contact or distribution list a
+// wrapped EntryID points to, or 0 for anything else
+// The wrapped EntryID of an item in a PST ends with its node identifier.
+func parseWrappedEntryID(entryID []byte) pst.Identifier {
+	if len(entryID) < 21+24 || !bytes.Equal(entryID[4:20], wrappedProviderUID) {
+		return 0
+	}
+	switch entryID[20] & 0x0F {
+	case wrappedContact, wrappedDistList:
+	default:
+		return 0
+	}
+	embedded := entryID[21:]
+	return pst.Identifier(binary.LittleEndian.Uint32(embedded[20:24]))
+}
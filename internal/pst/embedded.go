@@ -0,0 +1,144 @@
+package pst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/mooijtech/go-pst/v6/pkg"
+	"github.com/mooijtech/go-pst/v6/pkg/properties"
+)
+
+// Attachments holding a message, such as an email forwarded as an attachment
+// See MS-OXCMSG section 2.2.2.9
+const (
+	attachEmbeddedMessage = 5      // PidTagAttachMethod value ATTACH_EMBEDDED_MSG
+	propAttachDataObject  = 0x3701 // PidTagAttachDataObject, the subnode of the message
+)
+
+// maxEmbeddedDepth is how deep messages embedded in embedded messages are
+// followed, in case a malformed PST nests them endlessly
+const maxEmbeddedDepth = 8
+
+// embeddedMessage is a message attached to another, converted to RFC822
+type embeddedMessage struct {
+	name    string // File name for the attachment
+	content []byte
+}
+
+// embeddedMessages converts the messages attached to msg to RFC822, each with
+// its own embedded messages
+// depth is how many messages msg itself is embedded in. Attachments that
+// can't be read are left out.
+func embeddedMessages(msg *pst.Message, depth int) []embeddedMessage {
+	if depth >= maxEmbeddedDepth {
+		return nil
+	}
+	count, err := msg.GetAttachmentCount()
+	if err != nil {
+		return nil
+	}
+
+	var embedded []embeddedMessage
+	for i := 0; i < count; i++ {
+		attachment, err := msg.GetAttachment(i)
+		if err != nil || attachment.GetAttachMethod() != attachEmbeddedMessage {
+			continue
+		}
+		inner, err := readEmbeddedMessage(msg.File, attachment)
+		if err != nil {
+			continue
+		}
+		innerProps := &properties.Message{}
+		if err := populateProperties(inner, innerProps); err != nil {
+			continue
+		}
+		content, _, _ := buildMessage(inner, innerProps, depth+1)
+		if content == nil {
+			continue
+		}
+		embedded = append(embedded, embeddedMessage{
+			name:    embeddedFileName(innerProps.GetSubject()),
+			content: content,
+		})
+	}
+	return embedded
+}
+
+// readEmbeddedMessage opens the message an attachment holds, which is stored
+// in a subnode of the attachment
+func readEmbeddedMessage(file *pst.File, attachment *pst.Attachment) (*pst.Message, error) {
+	property, err := attachment.PropertyContext.GetPropertyByID(propAttachDataObject)
+	if err != nil {
+		return nil, err
+	}
+	// The value is the subnode's NID followed by its size (MS-PST section 2.3.3.5)
+	nid := property.HNID
+	if data := readBinaryProperty(attachment.PropertyContext, attachment.LocalDescriptors, propAttachDataObject); len(data) == 8 {
+		nid = pst.Identifier(binary.LittleEndian.Uint32(data))
+	}
+
+	localDescriptor, err := pst.FindLocalDescriptor(nid, attachment.LocalDescriptors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find embedded message: %w", err)
+	}
+	localDescriptors, err := file.GetLocalDescriptorsFromIdentifier(localDescriptor.LocalDescriptorsIdentifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded message subnodes: %w", err)
+	}
+	heapOnNode, err := file.GetHeapOnNodeFromLocalDescriptor(localDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded message: %w", err)
+	}
+	propContext, err := file.GetPropertyContext(heapOnNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded message properties: %w", err)
+	}
+
+	return &pst.Message{
+		File:             file,
+		Identifier:       localDescriptor.Identifier,
+		PropertyContext:  propContext,
+		LocalDescriptors: localDescriptors,
+		Properties:       &properties.Message{},
+	}, nil
+}
+
+// embeddedFileName names the .eml attachment of an embedded message after
+// its subject
+func embeddedFileName(subject string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(subject))
+	if name == "" {
+		name = "message"
+	}
+	return name + ".eml"
+}
+
+// writeEmbeddedMessages writes a multipart/mixed body: the message's own
+// bodies, then each embedded message as a message/rfc822 part
+// depth keeps the boundary apart from those of the messages it is embedded in.
+func writeEmbeddedMessages(buf *bytes.Buffer, bodyText, bodyHTML string, embedded []embeddedMessage, depth int) {
+	boundary := fmt.Sprintf("----=_Mixed_%d_%d", depth, time.Now().UnixNano())
+	buf.WriteString("Content-Type: multipart/mixed; boundary=\"" + boundary + "\"\r\n\r\n")
+
+	buf.WriteString("--" + boundary + "\r\n")
+	writeBodyPart(buf, bodyText, bodyHTML)
+	buf.WriteString("\r\n")
+
+	for _, message := range embedded {
+		buf.WriteString("--" + boundary + "\r\n")
+		buf.WriteString("Content-Type: message/rfc822\r\n")
+		buf.WriteString("Content-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": message.name}) + "\r\n\r\n")
+		buf.Write(message.content)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--" + boundary + "--\r\n")
+}
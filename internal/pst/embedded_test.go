@@ -0,0 +1,76 @@
+package pst
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedMessagesDepthLimit(t *testing.T) {
+	// At the limit the message isn't looked at, so a nil one is fine
+	if embedded := embeddedMessages(nil, maxEmbeddedDepth); embedded != nil {
+		t.Errorf("got %d embedded messages at depth %d, want none", len(embedded), maxEmbeddedDepth)
+	}
+}
+
+func TestEmbeddedFileName(t *testing.T) {
+	for subject, want := range map[string]string{
+		"Quarterly report":     "Quarterly report.eml",
+		" FW: Q1/Q2 figures? ": "FW_ Q1_Q2 figures_.eml",
+		"Line\tbreak":          "Line_break.eml",
+		"":                     "message.eml",
+	} {
+		if got := embeddedFileName(subject); got != want {
+			t.Errorf("embeddedFileName(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
+
+func TestWriteEmbeddedMessagesNested(t *testing.T) {
+	var inner bytes.Buffer
+	inner.WriteString("Subject: Inner\r\nMIME-Version: 1.0\r\n")
+	writeEmbeddedMessages(&inner, "inner text", "", nil, 2)
+
+	var outer bytes.Buffer
+	outer.WriteString("Subject: Outer\r\nMIME-Version: 1.0\r\n")
+	writeEmbeddedMessages(&outer, "outer text", "", []embeddedMessage{{name: "Inner.eml", content: inner.Bytes()}}, 1)
+
+	msg, err := mail.ReadMessage(&outer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+		if part.Header.Get("Content-Type") != "message/rfc822" {
+			continue
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "inner text") {
+			t.Errorf("embedded message lost its body: %q", content)
+		}
+	}
+	if len(types) != 2 || types[1] != "message/rfc822" {
+		t.Errorf("got parts %v, want the body and one message/rfc822", types)
+	}
+}
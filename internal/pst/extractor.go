@@ -2,49 +2,53 @@ package pst
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"mime"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mooijtech/go-pst/v6/pkg"
 	"github.com/mooijtech/go-pst/v6/pkg/properties"
 )
 
+// Stdout redirection shared by every extractor, as PSTs may be read concurrently
+var (
+	stdoutMu       sync.Mutex
+	stdoutUsers    int      // Callers currently suppressing stdout
+	stdoutOriginal *os.File // Stdout to restore when the last one is done
+)
+
 // suppressStdout temporarily redirects stdout to discard library warnings
-// Returns a function to restore stdout
+// Returns a function to restore stdout. Stdout stays redirected until every
+// concurrent caller has restored it, so output meant for the terminal must be
+// written to a writer captured beforehand rather than to os.Stdout.
 func suppressStdout() func() {
-	original := os.Stdout
-	os.Stdout, _ = os.Open(os.DevNull)
-	return func() {
-		os.Stdout = original
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	if stdoutUsers == 0 {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return func() {}
+		}
+		stdoutOriginal = os.Stdout
+		os.Stdout = devNull
 	}
-}
-
-// nonEmailFolders contains folder names that don't hold email messages.
-// These are standard Outlook folders for non-email item types.
-var nonEmailFolders = map[string]bool{
-	"calendar":        true,
-	"contacts":        true,
-	"tasks":           true,
-	"notes":           true,
-	"journal":         true,
-	"drafts":          true,
-	"outbox":          true,
-	"sync issues":     true,
-	"conflicts":       true,
-	"local failures":  true,
-	"server failures": true,
-	"junk e-mail":     true,
-	"rss feeds":       true,
-	"conversation history": true,
-}
+	stdoutUsers++
 
-// isNonEmailFolder checks if a folder name is a known non-email folder
-func isNonEmailFolder(name string) bool {
-	return nonEmailFolders[strings.ToLower(name)]
+	return func() {
+		stdoutMu.Lock()
+		defer stdoutMu.Unlock()
+		stdoutUsers--
+		if stdoutUsers == 0 {
+			os.Stdout.Close()
+			os.Stdout = stdoutOriginal
+		}
+	}
 }
 
 // Message represents an email message ready for upload
@@ -58,17 +62,42 @@ type Message struct {
 // Return an error to stop processing
 type MessageCallback func(folderName string, msg *Message) error
 
-// FolderCallback is called when starting a new folder
+// FolderCallback is called when starting a new folder, before any of its items
+// are read. folder.Path is the full path from the PST root, "/"-separated, and
+// is the folderName passed to the other callbacks.
 // Returns (skip bool, err error) - set skip=true to skip this folder
-type FolderCallback func(folderName string) (skip bool, err error)
-
-// ProgressCallback is called with status updates
-type ProgressCallback func(message string)
+type FolderCallback func(folder *FolderInfo) (skip bool, err error)
+
+// ProgressCallback is called after each item in a folder has been handled,
+// whether or not it was passed on to the message or contact callback, with the
+// item's size from the PST. Together with Prescan it drives progress reporting.
+type ProgressCallback func(folderName string, size int64)
+
+// SkipCallback is called when Process passes over items on its own rather than
+// at the request of a FolderCallback. count is the number of items skipped:
+// a folder's item count for a skipped folder, or 1 for a single item.
+type SkipCallback func(folderName, reason string, count int)
+
+// Reasons passed to a SkipCallback
+const (
+	SkipNonEmailFolder = "non-email folder"
+	SkipNotEmail       = "not an email item"
+	SkipUnreadable     = "unreadable"
+	SkipNoBody         = "no body"
+	SkipFiltered       = "filtered" // Prefix; the rest names the failed test, e.g. "filtered: date"
+)
 
 // Extractor handles PST file reading using pure Go
 type Extractor struct {
 	reader  io.ReadCloser
 	pstFile *pst.File
+	onSkip  SkipCallback
+	filter  *MessageFilter
+	special specialFolders // Read on first use by specialFolders
+
+	skipPhotos   bool   // Leave contact pictures out of vCards
+	groupStyle   string // GroupsKind or GroupsApple
+	vcardVersion string // VCard30 or VCard40
 }
 
 // NewExtractor creates a new PST extractor
@@ -94,9 +123,42 @@ func (e *Extractor) Open(pstPath string) error {
 	return nil
 }
 
+// SetSkipCallback registers a callback for folders and items Process skips
+func (e *Extractor) SetSkipCallback(onSkip SkipCallback) {
+	e.onSkip = onSkip
+}
+
+// SetFilter makes Process pass on only messages the filter accepts; the others
+// are reported to the skip callback with a reason starting with SkipFiltered
+// A nil filter passes everything
+func (e *Extractor) SetFilter(filter *MessageFilter) error {
+	if filter != nil {
+		if err := filter.Compile(); err != nil {
+			return err
+		}
+	}
+	e.filter = filter
+	return nil
+}
+
+// SetSkipPhotos leaves contact pictures out of the vCards ProcessContacts
+// builds, for servers that limit the size of a card
+func (e *Extractor) SetSkipPhotos(skip bool) {
+	e.skipPhotos = skip
+}
+
+// skipped reports skipped items to the skip callback, if any
+func (e *Extractor) skipped(folderName, reason string, count int) {
+	if e.onSkip != nil {
+		e.onSkip(folderName, reason, count)
+	}
+}
+
 // Process streams through the PST file, calling callbacks for each folder and message
 // Messages are processed one at a time - only one message is in memory at once
+// Processing stops with ctx's error before the next folder or message once ctx is done
 func (e *Extractor) Process(
+	ctx context.Context,
 	onFolder FolderCallback,
 	onMessage MessageCallback,
 	onProgress ProgressCallback,
@@ -105,21 +167,21 @@ func (e *Extractor) Process(
 		return fmt.Errorf("PST file not opened")
 	}
 
-	return e.pstFile.WalkFolders(func(folder *pst.Folder) error {
-		folderName := folder.Name
+	return e.walkFolders(func(folder *pst.Folder, info *FolderInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		folderName := info.Path
 
 		// Skip non-email folders (Calendar, Contacts, Tasks, etc.)
-		if isNonEmailFolder(folderName) {
+		if !info.Type.IsMail() {
+			e.skipped(folderName, SkipNonEmailFolder, int(folder.MessageCount))
 			return nil
 		}
 
-		if onProgress != nil {
-			onProgress(fmt.Sprintf("Processing folder: %s", folderName))
-		}
-
 		// Check if we should skip this folder
 		if onFolder != nil {
-			skip, err := onFolder(folderName)
+			skip, err := onFolder(info)
 			if err != nil {
 				return err
 			}
@@ -139,45 +201,77 @@ func (e *Extractor) Process(
 		// "Unmapped message class X, falling back to properties.Message..."
 		// These are informational only - the messages are still processed correctly.
 		for func() bool { restore := suppressStdout(); defer restore(); return messageIterator.Next() }() {
-			msg := messageIterator.Value()
-
-			// Get the properties - only process email messages
-			msgProps, ok := msg.Properties.(*properties.Message)
-			if !ok {
-				continue
-			}
-
-			// Populate the properties from the PST
-			if err := msg.PropertyContext.Populate(msgProps, msg.LocalDescriptors); err != nil {
-				continue
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			msg := messageIterator.Value()
+			setANSIItemType(msg)
 
-			// Build RFC822 message
-			content, msgID, msgDate := buildRFC822Message(msgProps)
-			if content == nil {
-				continue
+			if err := e.processMessage(folderName, msg, onMessage); err != nil {
+				return err
 			}
-
-			// Call the message callback immediately - message is uploaded here
-			if onMessage != nil {
-				pstMsg := &Message{
-					ID:      msgID,
-					Date:    msgDate,
-					Content: content,
-				}
-				if err := onMessage(folderName, pstMsg); err != nil {
-					return err
-				}
+			if onProgress != nil {
+				onProgress(folderName, int64(readInt32Property(msg.PropertyContext, msg.LocalDescriptors, propMessageSize)))
 			}
-			// Message goes out of scope here - memory freed
 		}
 
 		return messageIterator.Err()
 	})
 }
 
-// ProcessContacts extracts contacts from Contacts folders in the PST file.
+// processMessage converts one PST item to RFC822 and passes it to onMessage
+// Items that aren't readable email messages are reported to the skip callback
+func (e *Extractor) processMessage(folderName string, msg *pst.Message, onMessage MessageCallback) error {
+	// Get the properties - only process email messages
+	msgProps, ok := msg.Properties.(*properties.Message)
+	if !ok {
+		e.skipped(folderName, SkipNotEmail, 1)
+		return nil
+	}
+
+	// Populate the properties from the PST
+	if err := populateProperties(msg, msgProps); err != nil {
+		e.skipped(folderName, SkipUnreadable, 1)
+		return nil
+	}
+
+	// Apply the filter before the costly conversion
+	if e.filter != nil {
+		if reason := e.filter.filterReason(msg, msgProps); reason != "" {
+			e.skipped(folderName, reason, 1)
+			return nil
+		}
+	}
+
+	// Build RFC822 message
+	content, msgID, msgDate := buildMessage(msg, msgProps, 0)
+	if content == nil {
+		e.skipped(folderName, SkipNoBody, 1)
+		return nil
+	}
+
+	// Call the message callback immediately - message is uploaded here
+	if onMessage != nil {
+		pstMsg := &Message{
+			ID:      msgID,
+			Date:    msgDate,
+			Content: content,
+		}
+		if err := onMessage(folderName, pstMsg); err != nil {
+			return err
+		}
+	}
+	// Message goes out of scope here - memory freed
+	return nil
+}
+
+// ProcessContacts extracts contacts from Contacts folders in the PST file,
+// whatever their name, along with any contacts subfolders.
+// Distribution lists follow the contacts as group vCards, so their members
+// can refer to the contacts by UID.
+// Processing stops with ctx's error before the next contact once ctx is done
 func (e *Extractor) ProcessContacts(
+	ctx context.Context,
 	onContact ContactCallback,
 	onProgress ProgressCallback,
 ) error {
@@ -185,13 +279,29 @@ func (e *Extractor) ProcessContacts(
 		return fmt.Errorf("PST file not opened")
 	}
 
-	return e.pstFile.WalkFolders(func(folder *pst.Folder) error {
-		folderName := folder.Name
+	// The main Contacts folder is the one the PST names, or else the first
+	var defaultFolder pst.Identifier
+	for id, folderType := range e.specialFolders() {
+		if folderType == FolderContacts {
+			defaultFolder = id
+		}
+	}
+
+	// The UID of each contact and list by PST node, for the lists' members
+	uids := make(map[pst.Identifier]string)
+	var lists []*distList
+
+	err := e.walkFolders(func(folder *pst.Folder, info *FolderInfo) error {
+		folderName := info.Path
 
 		// Only process Contacts folders
-		if !strings.Contains(strings.ToLower(folderName), "contacts") {
+		if info.Type != FolderContacts {
 			return nil
 		}
+		if defaultFolder == 0 {
+			defaultFolder = folder.Identifier
+		}
+		isDefault := folder.Identifier == defaultFolder
 
 		// Get messages in this folder
 		messageIterator, err := folder.GetMessageIterator()
@@ -199,45 +309,106 @@ func (e *Extractor) ProcessContacts(
 			return nil
 		}
 
-		if onProgress != nil {
-			onProgress(fmt.Sprintf("Processing: %s", folderName))
-		}
-
 		// Suppress stdout during Next() to silence go-pst library warnings
 		for func() bool { restore := suppressStdout(); defer restore(); return messageIterator.Next() }() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			msg := messageIterator.Value()
+			setANSIItemType(msg)
 
-			// Get the properties - only process Contact items
-			contactProps, ok := msg.Properties.(*properties.Contact)
-			if !ok {
-				continue
+			if list := e.readDistList(folderName, isDefault, msg); list != nil {
+				uids[msg.Identifier] = groupUID(list.folder, list.name)
+				lists = append(lists, list)
+			} else if err := e.processContact(folderName, isDefault, msg, uids, onContact); err != nil {
+				return err
 			}
-
-			// Populate the Contact properties from the PST
-			if err := msg.PropertyContext.Populate(contactProps, msg.LocalDescriptors); err != nil {
-				continue
+			if onProgress != nil {
+				onProgress(folderName, int64(readInt32Property(msg.PropertyContext, msg.LocalDescriptors, propMessageSize)))
 			}
+		}
 
-			// Also populate Message properties (for mobile phone, etc.)
-			msgProps := &properties.Message{}
-			msg.PropertyContext.Populate(msgProps, msg.LocalDescriptors)
+		return messageIterator.Err()
+	})
+	if err != nil {
+		return err
+	}
 
-			// Build Contact (pass PropertyContext and LocalDescriptors for named property access)
-			contact := buildContact(e.pstFile, msg.PropertyContext, msg.LocalDescriptors, contactProps, msgProps)
-			if contact == nil {
-				continue
+	for _, list := range lists {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if onContact != nil {
+			group := buildGroup(list, uids, e.groupStyle)
+			if e.vcardVersion == VCard40 {
+				toVCard4(group.Card)
 			}
-
-			// Call the contact callback
-			if onContact != nil {
-				if err := onContact(contact); err != nil {
-					return err
-				}
+			if err := onContact(group); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
 
-		return messageIterator.Err()
-	})
+// readDistList reads a distribution list, or returns nil if the item isn't one
+func (e *Extractor) readDistList(folderName string, isDefault bool, msg *pst.Message) *distList {
+	if _, ok := msg.Properties.(*properties.AddressBook); !ok {
+		return nil
+	}
+	msgProps := &properties.Message{}
+	populateProperties(msg, msgProps)
+
+	list := readDistList(e.pstFile, msg, msgProps)
+	if list == nil {
+		return nil
+	}
+	list.folder = folderName
+	list.isDefault = isDefault
+	return list
+}
+
+// processContact converts one PST item to a vCard and passes it to onContact,
+// recording its UID in uids
+// Items that aren't contacts are ignored
+func (e *Extractor) processContact(folderName string, isDefault bool, msg *pst.Message, uids map[pst.Identifier]string, onContact ContactCallback) error {
+	// Get the properties - only process Contact items
+	contactProps, ok := msg.Properties.(*properties.Contact)
+	if !ok {
+		return nil
+	}
+
+	// Populate the Contact properties from the PST
+	if err := populateProperties(msg, contactProps); err != nil {
+		return nil
+	}
+
+	// Also populate Message properties (for mobile phone, etc.)
+	msgProps := &properties.Message{}
+	populateProperties(msg, msgProps)
+
+	// Build Contact (pass PropertyContext and LocalDescriptors for named property access)
+	contact := buildContact(e.pstFile, msg.PropertyContext, msg.LocalDescriptors, contactProps, msgProps)
+	if contact == nil {
+		return nil
+	}
+	contact.Folder = folderName
+	contact.DefaultFolder = isDefault
+	uids[msg.Identifier] = contact.UID
+	if !e.skipPhotos {
+		if photo := contactPhoto(msg); photo != nil {
+			addPhoto(contact.Card, photo)
+		}
+	}
+	if e.vcardVersion == VCard40 {
+		toVCard4(contact.Card)
+	}
+
+	// Call the contact callback
+	if onContact != nil {
+		return onContact(contact)
+	}
+	return nil
 }
 
 // Close closes the PST file
@@ -253,18 +424,43 @@ func (e *Extractor) Close() error {
 	return nil
 }
 
+// buildMessage converts a PST message to RFC822, reading the bodies go-pst
+// doesn't populate and the messages embedded in it
+// depth is how many messages msg is embedded in, 0 for a folder's messages.
+func buildMessage(msg *pst.Message, msgProps *properties.Message, depth int) ([]byte, string, time.Time) {
+	// Outlook usually stores the HTML body as binary, in the internet code page
+	if msgProps.GetBodyHtml() == "" {
+		if bodyHTML := readHTMLBody(msg.PropertyContext, msg.LocalDescriptors); bodyHTML != "" {
+			msgProps.BodyHtml = &bodyHTML
+		}
+	}
+
+	// Older messages may only have a compressed RTF body
+	var rtf []byte
+	if msgProps.GetBody() == "" && msgProps.GetBodyHtml() == "" {
+		rtf = readBinaryProperty(msg.PropertyContext, msg.LocalDescriptors, propRTFCompressed)
+	}
+
+	return buildRFC822Message(msgProps, rtf, embeddedMessages(msg, depth), depth)
+}
+
 // buildRFC822Message constructs an RFC822 email from PST message properties
+// rtf is the compressed RTF body, used when the message has no text or HTML body
+// embedded are the messages attached to it, written as message/rfc822 parts
 // Returns nil if the message has no body content (e.g., Outlook-only calendar objects)
-func buildRFC822Message(msg *properties.Message) ([]byte, string, time.Time) {
+func buildRFC822Message(msg *properties.Message, rtf []byte, embedded []embeddedMessage, depth int) ([]byte, string, time.Time) {
 	// Get body content early - skip messages with no body
 	// This filters out Outlook-specific objects (meeting requests, calendar items, etc.)
 	// that have no meaningful email content
 	bodyText := msg.GetBody()
 	bodyHTML := msg.GetBodyHtml()
+	if bodyText == "" && bodyHTML == "" && len(rtf) > 0 {
+		bodyText, bodyHTML = rtfBodies(rtf)
+	}
 	transportHeaders := msg.GetTransportMessageHeaders()
 
 	// If there's no body and no transport headers, this is likely an Outlook-only object
-	if bodyText == "" && bodyHTML == "" && transportHeaders == "" {
+	if bodyText == "" && bodyHTML == "" && transportHeaders == "" && len(embedded) == 0 {
 		return nil, "", time.Time{}
 	}
 
@@ -273,31 +469,34 @@ func buildRFC822Message(msg *properties.Message) ([]byte, string, time.Time) {
 	// Get message metadata
 	messageID := msg.GetInternetMessageId()
 	if messageID == "" {
-		// Generate a fallback Message-ID
-		messageID = fmt.Sprintf("<%d.pst-import@localhost>", time.Now().UnixNano())
+		// Generate a fallback Message-ID, stable across runs so resume and verify can find it
+		messageID = fallbackMessageID(msg, bodyText, bodyHTML)
 	}
 
-	// Parse the date (GetClientSubmitTime returns Unix timestamp in seconds)
-	msgDate := time.Now()
-	if clientSubmitTime := msg.GetClientSubmitTime(); clientSubmitTime > 0 {
-		parsed := time.Unix(clientSubmitTime, 0)
-		// Validate the date is reasonable (between 1990 and 2100)
-		if parsed.Year() >= 1990 && parsed.Year() <= 2100 {
-			msgDate = parsed
-		}
-	}
-	// Also try message delivery time
-	if msgDate.Year() < 1990 {
-		if deliveryTime := msg.GetMessageDeliveryTime(); deliveryTime > 0 {
-			parsed := time.Unix(deliveryTime, 0)
-			if parsed.Year() >= 1990 && parsed.Year() <= 2100 {
-				msgDate = parsed
-			}
-		}
+	// Use the submit time, else the delivery time (go-pst returns Unix nanoseconds)
+	msgDate := messageDate(msg)
+	if msgDate.IsZero() {
+		msgDate = time.Now()
 	}
 
-	if transportHeaders != "" {
+	if transportHeaders != "" && len(embedded) > 0 {
+		// Use original headers, with the body rebuilt to hold the embedded messages
+		headers := removeHeader(removeHeader(transportHeaders, "Content-Type"), "Content-Transfer-Encoding")
+		if headerValue(headers, "MIME-Version") == "" {
+			headers = replaceHeader(headers, "MIME-Version", "1.0")
+		}
+		buf.WriteString(headers)
+		writeEmbeddedMessages(&buf, bodyText, bodyHTML, embedded, depth)
+	} else if transportHeaders != "" {
 		// Use original headers, but we may need to add body
+		var body []byte
+		if strings.Contains(strings.ToLower(transportHeaders), "multipart") {
+			// Original message was multipart, body should follow headers
+			body = []byte(bodyText)
+		} else {
+			// Simple message, append body in the charset the headers declare
+			transportHeaders, body = encodeTransportBody(transportHeaders, bodyText, bodyHTML)
+		}
 		buf.WriteString(transportHeaders)
 
 		// Ensure headers end with blank line
@@ -307,19 +506,7 @@ func buildRFC822Message(msg *properties.Message) ([]byte, string, time.Time) {
 			}
 			buf.WriteString("\r\n")
 		}
-
-		// Check if headers indicate multipart
-		if strings.Contains(strings.ToLower(transportHeaders), "multipart") {
-			// Original message was multipart, body should follow headers
-			buf.WriteString(bodyText)
-		} else {
-			// Simple message, append body
-			if bodyText != "" {
-				buf.WriteString(bodyText)
-			} else if bodyHTML != "" {
-				buf.WriteString(bodyHTML)
-			}
-		}
+		buf.Write(body)
 	} else {
 		// Build headers from scratch
 		writeHeader(&buf, "Message-ID", messageID)
@@ -341,44 +528,62 @@ func buildRFC822Message(msg *properties.Message) ([]byte, string, time.Time) {
 		}
 
 		// Determine content type and write body
-		if bodyHTML != "" && bodyText != "" {
-			// Multipart alternative
-			boundary := fmt.Sprintf("----=_Part_%d", time.Now().UnixNano())
-			writeHeader(&buf, "MIME-Version", "1.0")
-			writeHeader(&buf, "Content-Type", fmt.Sprintf("multipart/alternative; boundary=\"%s\"", boundary))
-			buf.WriteString("\r\n")
-
-			// Plain text part
-			buf.WriteString("--" + boundary + "\r\n")
-			buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
-			buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
-			buf.WriteString(bodyText)
-			buf.WriteString("\r\n")
-
-			// HTML part
-			buf.WriteString("--" + boundary + "\r\n")
-			buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
-			buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
-			buf.WriteString(bodyHTML)
-			buf.WriteString("\r\n")
-
-			buf.WriteString("--" + boundary + "--\r\n")
-		} else if bodyHTML != "" {
-			writeHeader(&buf, "MIME-Version", "1.0")
-			writeHeader(&buf, "Content-Type", "text/html; charset=utf-8")
-			buf.WriteString("\r\n")
-			buf.WriteString(bodyHTML)
+		writeHeader(&buf, "MIME-Version", "1.0")
+		if len(embedded) > 0 {
+			writeEmbeddedMessages(&buf, bodyText, bodyHTML, embedded, depth)
 		} else {
-			writeHeader(&buf, "MIME-Version", "1.0")
-			writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
-			buf.WriteString("\r\n")
-			buf.WriteString(bodyText)
+			writeBodyPart(&buf, bodyText, bodyHTML)
 		}
 	}
 
 	return buf.Bytes(), strings.Trim(messageID, "<>"), msgDate
 }
 
+// writeBodyPart writes the Content-Type of a message's bodies, a blank line
+// and the bodies: multipart/alternative if it has both text and HTML
+func writeBodyPart(buf *bytes.Buffer, bodyText, bodyHTML string) {
+	if bodyHTML != "" && bodyText != "" {
+		// Multipart alternative
+		boundary := fmt.Sprintf("----=_Part_%d", time.Now().UnixNano())
+		writeHeader(buf, "Content-Type", fmt.Sprintf("multipart/alternative; boundary=\"%s\"", boundary))
+		buf.WriteString("\r\n")
+
+		// Plain text part
+		buf.WriteString("--" + boundary + "\r\n")
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		buf.WriteString(bodyText)
+		buf.WriteString("\r\n")
+
+		// HTML part
+		buf.WriteString("--" + boundary + "\r\n")
+		buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		buf.WriteString(bodyHTML)
+		buf.WriteString("\r\n")
+
+		buf.WriteString("--" + boundary + "--\r\n")
+	} else if bodyHTML != "" {
+		writeHeader(buf, "Content-Type", "text/html; charset=utf-8")
+		buf.WriteString("\r\n")
+		buf.WriteString(bodyHTML)
+	} else {
+		writeHeader(buf, "Content-Type", "text/plain; charset=utf-8")
+		buf.WriteString("\r\n")
+		buf.WriteString(bodyText)
+	}
+}
+
+// fallbackMessageID derives a Message-ID from the message content
+func fallbackMessageID(msg *properties.Message, bodyText, bodyHTML string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%d\x00", msg.GetSubject(), msg.GetSenderEmailAddress(),
+		msg.GetDisplayTo(), msg.GetClientSubmitTime(), msg.GetMessageDeliveryTime())
+	h.Write([]byte(bodyText))
+	h.Write([]byte(bodyHTML))
+	return fmt.Sprintf("<%x.pst-import@localhost>", h.Sum(nil)[:16])
+}
+
 // writeHeader writes a header line to the buffer
 func writeHeader(buf *bytes.Buffer, name, value string) {
 	if value != "" {
@@ -0,0 +1,284 @@
+package pst
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mooijtech/go-pst/v6/pkg"
+	"github.com/mooijtech/go-pst/v6/pkg/properties"
+)
+
+// Property IDs read for filtering
+// See MS-OXPROPS
+const (
+	propEmailAddress      = 0x3003 // PidTagEmailAddress
+	propSMTPAddress       = 0x39FE // PidTagSmtpAddress
+	propSenderSMTPAddress = 0x5D01 // PidTagSenderSmtpAddress
+
+	recipientTableIdentifier = 0x692 // Local descriptor NID of a message's recipient table
+)
+
+// MessageFilter selects which messages Process passes on, using properties
+// read before the message is converted to RFC822
+//
+// Address patterns containing "@" are globs matched against the whole
+// address; others match a domain and its subdomains ("example.com" matches
+// news.example.com). Class patterns are globs such as "IPM.Note*". All
+// matching ignores case, and an empty field doesn't filter.
+type MessageFilter struct {
+	// Date range, against the submit time or else the delivery time
+	// Messages without either date are kept
+	Since  time.Time // Keep messages on or after this time
+	Before time.Time // Keep messages before this time
+
+	Senders           []string // Keep only messages from a matching sender
+	ExcludeSenders    []string // Drop messages from a matching sender
+	Recipients        []string // Keep only messages with a matching To/Cc/Bcc recipient
+	ExcludeRecipients []string // Drop messages with any matching recipient
+
+	Subject        string // Keep only messages whose subject matches this regexp
+	ExcludeSubject string // Drop messages whose subject matches this regexp
+
+	MaxSize int64 // Drop messages larger than this many bytes (PR_MESSAGE_SIZE)
+
+	Classes        []string // Keep only messages with a matching PR_MESSAGE_CLASS
+	ExcludeClasses []string // Drop messages with a matching PR_MESSAGE_CLASS
+
+	senders, excludeSenders       []*regexp.Regexp
+	recipients, excludeRecipients []*regexp.Regexp
+	subject, excludeSubject       *regexp.Regexp
+	classes, excludeClasses       []*regexp.Regexp
+}
+
+// Compile checks and compiles the patterns; SetFilter calls it
+func (f *MessageFilter) Compile() error {
+	var err error
+	for _, list := range []struct {
+		patterns []string
+		compiled *[]*regexp.Regexp
+		compile  func([]string) ([]*regexp.Regexp, error)
+	}{
+		{f.Senders, &f.senders, compileAddressPatterns},
+		{f.ExcludeSenders, &f.excludeSenders, compileAddressPatterns},
+		{f.Recipients, &f.recipients, compileAddressPatterns},
+		{f.ExcludeRecipients, &f.excludeRecipients, compileAddressPatterns},
+		{f.Classes, &f.classes, compilePatterns},
+		{f.ExcludeClasses, &f.excludeClasses, compilePatterns},
+	} {
+		if *list.compiled, err = list.compile(list.patterns); err != nil {
+			return err
+		}
+	}
+
+	if f.subject, err = compileOptional(f.Subject); err != nil {
+		return fmt.Errorf("invalid subject pattern: %w", err)
+	}
+	if f.excludeSubject, err = compileOptional(f.ExcludeSubject); err != nil {
+		return fmt.Errorf("invalid subject pattern: %w", err)
+	}
+	return nil
+}
+
+// ParseDate parses a date as YYYY-MM-DD in local time, RFC 3339, or an age
+// before now such as 90d, 2w, 6m or 7y
+func ParseDate(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if len(value) >= 2 {
+		if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n >= 0 {
+			switch value[len(value)-1] {
+			case 'd':
+				return now.AddDate(0, 0, -n), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*n), nil
+			case 'm':
+				return now.AddDate(0, -n, 0), nil
+			case 'y':
+				return now.AddDate(-n, 0, 0), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD, RFC 3339 or an age like 90d, 6m, 7y)", value)
+}
+
+// ParseSize parses a byte count such as 500000, 512KB, 25MB or 1.5GB
+func ParseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use bytes or a KB, MB or GB suffix)", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// compileOptional compiles a case-insensitive regexp, or returns nil for ""
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + expr)
+}
+
+// compileAddressPatterns compiles address and domain patterns
+func compileAddressPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		var expr string
+		if strings.Contains(pattern, "@") {
+			expr = globToRegexp(pattern)
+		} else {
+			// Any address at the domain or one of its subdomains
+			domain := strings.TrimPrefix(globToRegexp(strings.TrimPrefix(pattern, ".")), "^")
+			expr = `^[^@]*@(?:[^@]*\.)?` + domain
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// filterReason returns the skip reason for a message the filter rejects, or ""
+func (f *MessageFilter) filterReason(msg *pst.Message, props *properties.Message) string {
+	if !f.Since.IsZero() || !f.Before.IsZero() {
+		if date := messageDate(props); !date.IsZero() {
+			if (!f.Since.IsZero() && date.Before(f.Since)) || (!f.Before.IsZero() && !date.Before(f.Before)) {
+				return SkipFiltered + ": date"
+			}
+		}
+	}
+
+	if f.MaxSize > 0 && int64(props.GetMessageSize()) > f.MaxSize {
+		return SkipFiltered + ": size"
+	}
+
+	if len(f.classes) > 0 || len(f.excludeClasses) > 0 {
+		class := readStringProperty(msg.PropertyContext, msg.LocalDescriptors, propMessageClass)
+		if (len(f.classes) > 0 && !matchesAny(f.classes, class)) || matchesAny(f.excludeClasses, class) {
+			return SkipFiltered + ": class"
+		}
+	}
+
+	if f.subject != nil || f.excludeSubject != nil {
+		subject := props.GetSubject()
+		if (f.subject != nil && !f.subject.MatchString(subject)) || (f.excludeSubject != nil && f.excludeSubject.MatchString(subject)) {
+			return SkipFiltered + ": subject"
+		}
+	}
+
+	if len(f.senders) > 0 || len(f.excludeSenders) > 0 {
+		sender := senderAddress(msg, props)
+		if (len(f.senders) > 0 && !matchesAny(f.senders, sender)) || matchesAny(f.excludeSenders, sender) {
+			return SkipFiltered + ": sender"
+		}
+	}
+
+	if len(f.recipients) > 0 || len(f.excludeRecipients) > 0 {
+		recipients := recipientAddresses(msg)
+		matched := false
+		for _, recipient := range recipients {
+			if matchesAny(f.excludeRecipients, recipient) {
+				return SkipFiltered + ": recipient"
+			}
+			matched = matched || matchesAny(f.recipients, recipient)
+		}
+		if len(f.recipients) > 0 && !matched {
+			return SkipFiltered + ": recipient"
+		}
+	}
+
+	return ""
+}
+
+// messageDate returns when a message was sent, or else delivered, or the zero
+// time if neither is set to a plausible date
+func messageDate(props *properties.Message) time.Time {
+	for _, nanos := range []int64{props.GetClientSubmitTime(), props.GetMessageDeliveryTime()} {
+		if nanos <= 0 {
+			continue
+		}
+		if t := time.Unix(0, nanos); t.Year() >= 1990 && t.Year() <= 2100 {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// senderAddress returns the sender's SMTP address, or "" if only an Exchange
+// address is known
+func senderAddress(msg *pst.Message, props *properties.Message) string {
+	if address := readStringProperty(msg.PropertyContext, msg.LocalDescriptors, propSenderSMTPAddress); address != "" {
+		return address
+	}
+	for _, address := range []string{props.GetSenderEmailAddress(), props.GetSentRepresentingEmailAddress()} {
+		if strings.Contains(address, "@") {
+			return address
+		}
+	}
+	return ""
+}
+
+// recipientAddresses returns the SMTP addresses in a message's recipient table
+func recipientAddresses(msg *pst.Message) []string {
+	localDescriptor, err := pst.FindLocalDescriptor(recipientTableIdentifier, msg.LocalDescriptors)
+	if err != nil {
+		return nil
+	}
+	heapOnNode, err := msg.File.GetHeapOnNodeFromLocalDescriptor(localDescriptor)
+	if err != nil {
+		return nil
+	}
+	localDescriptors, err := msg.File.GetLocalDescriptorsFromIdentifier(localDescriptor.LocalDescriptorsIdentifier)
+	if err != nil {
+		return nil
+	}
+	tableContext, err := msg.File.GetTableContext(heapOnNode, localDescriptors, propSMTPAddress, propEmailAddress)
+	if err != nil {
+		return nil
+	}
+
+	var addresses []string
+	for _, row := range tableContext.Properties {
+		var smtp, email string
+		for _, property := range row {
+			reader, err := tableContext.GetPropertyReader(property, localDescriptors...)
+			if err != nil {
+				continue
+			}
+			switch property.ID {
+			case propSMTPAddress:
+				smtp = readerString(reader)
+			case propEmailAddress:
+				email = readerString(reader)
+			}
+		}
+		if smtp == "" && strings.Contains(email, "@") {
+			smtp = email
+		}
+		if smtp != "" {
+			addresses = append(addresses, smtp)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,329 @@
+package pst
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mooijtech/go-pst/v6/pkg"
+)
+
+// Property IDs read directly from message property contexts
+// See MS-OXPROPS
+const (
+	propMessageClass        = 0x001A // PidTagMessageClass
+	propClientSubmitTime    = 0x0039 // PidTagClientSubmitTime
+	propMessageDeliveryTime = 0x0E06 // PidTagMessageDeliveryTime
+	propMessageSize         = 0x0E08 // PidTagMessageSize
+)
+
+// Columns of a folder's subfolder table
+const (
+	propDisplayName  = 0x3001 // PidTagDisplayName
+	propContentCount = 0x3602 // PidTagContentCount
+	propSubfolders   = 0x360A // PidTagSubfolders
+	propFolderID     = 0x67F2 // PidTagLtpRowId, the folder's node
+)
+
+// FolderInfo describes a folder in the PST hierarchy
+type FolderInfo struct {
+	Path         string // Full path from the PST root, "/"-separated
+	Name         string
+	Depth        int // 0 for top-level folders
+	MessageCount int // Item count from the parent's folder table
+
+	Type   FolderType
+	Class  string     // PR_CONTAINER_CLASS, e.g. "IPF.Note"; often empty in old PSTs
+	Within FolderType // Type of the outermost special folder this one is inside of, or ""
+}
+
+// ItemInfo summarizes any item in a folder (mail, contact, appointment, ...)
+type ItemInfo struct {
+	Class string // PR_MESSAGE_CLASS, e.g. "IPM.Note"
+	Size  int64  // PR_MESSAGE_SIZE in bytes
+	Date  time.Time
+}
+
+// ItemCallback is called for each item visited by Scan
+// Return an error to stop processing
+type ItemCallback func(folder *FolderInfo, item *ItemInfo) error
+
+// walkFolders visits every folder below the root, parents before children
+func (e *Extractor) walkFolders(fn func(folder *pst.Folder, info *FolderInfo) error) error {
+	if e.pstFile == nil {
+		return fmt.Errorf("PST file not opened")
+	}
+
+	root, err := e.pstFile.GetRootFolder()
+	if err != nil {
+		return fmt.Errorf("failed to read root folder: %w", err)
+	}
+	special := e.specialFolders()
+
+	var walk func(parent *pst.Folder, parentInfo *FolderInfo) error
+	walk = func(parent *pst.Folder, parentInfo *FolderInfo) error {
+		parentPath, depth := parentInfo.Path, parentInfo.Depth+1
+		subFolders, err := readSubFolders(parent)
+		if err != nil {
+			return fmt.Errorf("failed to read subfolders of %q: %w", parentPath, err)
+		}
+
+		for i := range subFolders {
+			folder := &subFolders[i]
+			path := folder.Name
+			if parentPath != "" {
+				path = parentPath + "/" + folder.Name
+			}
+
+			info := &FolderInfo{
+				Path:         path,
+				Name:         folder.Name,
+				Depth:        depth,
+				MessageCount: int(folder.MessageCount),
+				Within:       parentInfo.Within,
+			}
+			if propContext, localDescriptors, err := folderPropertyContext(e.pstFile, folder.Identifier); err == nil {
+				info.Class = readStringProperty(propContext, localDescriptors, propContainerClass)
+			}
+			info.Type = special.folderType(folder.Identifier, info.Class, folder.Name)
+			if info.Within == "" && info.Type != FolderMail {
+				info.Within = info.Type
+			}
+
+			if err := fn(folder, info); err != nil {
+				return err
+			}
+			if err := walk(folder, info); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(&root, &FolderInfo{Depth: -1})
+}
+
+// readSubFolders returns the folders directly below parent
+// Unlike go-pst's GetSubFolders, it also reads the 8-bit folder names of ANSI
+// PSTs, which are taken to be in Windows-1252.
+func readSubFolders(parent *pst.Folder) ([]pst.Folder, error) {
+	if !parent.HasSubFolders {
+		return nil, nil
+	}
+	tableContext, err := parent.GetSubFoldersTableContext()
+	if err != nil {
+		return nil, err
+	}
+
+	subFolders := make([]pst.Folder, 0, len(tableContext.Properties))
+	for _, row := range tableContext.Properties {
+		folder := pst.Folder{File: parent.File}
+		for _, property := range row {
+			reader, err := tableContext.GetPropertyReader(property)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read folder table: %w", err)
+			}
+			switch property.ID {
+			case propDisplayName:
+				if property.Type == pst.PropertyTypeString8 {
+					folder.Name = decodeCodePage([]byte(readerString(reader)), codePageWindows1252)
+				} else {
+					folder.Name = readerString(reader)
+				}
+			case propFolderID:
+				identifier, _ := reader.GetInteger32()
+				folder.Identifier = pst.Identifier(identifier)
+			case propContentCount:
+				folder.MessageCount, _ = reader.GetInteger32()
+			case propSubfolders:
+				folder.HasSubFolders, _ = reader.GetBoolean()
+			}
+		}
+		subFolders = append(subFolders, folder)
+	}
+	return subFolders, nil
+}
+
+// Folders returns the folder tree in display order (parents before children)
+func (e *Extractor) Folders() ([]FolderInfo, error) {
+	var folders []FolderInfo
+	err := e.walkFolders(func(_ *pst.Folder, info *FolderInfo) error {
+		folders = append(folders, *info)
+		return nil
+	})
+	return folders, err
+}
+
+// Scan visits every item in every folder without building messages,
+// reading only the class, size and date of each item
+func (e *Extractor) Scan(onItem ItemCallback) error {
+	return e.walkFolders(func(folder *pst.Folder, info *FolderInfo) error {
+		messageIterator, err := folder.GetMessageIterator()
+		if err != nil {
+			// Some folders might not have messages
+			return nil
+		}
+
+		// Suppress stdout during Next() to silence go-pst library warnings
+		for func() bool { restore := suppressStdout(); defer restore(); return messageIterator.Next() }() {
+			msg := messageIterator.Value()
+
+			item := &ItemInfo{
+				Class: readStringProperty(msg.PropertyContext, msg.LocalDescriptors, propMessageClass),
+				Size:  int64(readInt32Property(msg.PropertyContext, msg.LocalDescriptors, propMessageSize)),
+				Date:  readTimeProperty(msg.PropertyContext, msg.LocalDescriptors, propClientSubmitTime),
+			}
+			if item.Date.IsZero() {
+				item.Date = readTimeProperty(msg.PropertyContext, msg.LocalDescriptors, propMessageDeliveryTime)
+			}
+
+			if err := onItem(info, item); err != nil {
+				return err
+			}
+		}
+
+		return messageIterator.Err()
+	})
+}
+
+// readStringProperty reads a Unicode or 8-bit string property
+// Returns empty string if the property is missing or unreadable
+func readStringProperty(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, propID uint16) string {
+	reader, err := propContext.GetPropertyReader(propID, localDescriptors)
+	if err != nil {
+		return ""
+	}
+	return readerString(reader)
+}
+
+// readerString reads a Unicode or 8-bit string value, or "" for other types
+func readerString(reader pst.PropertyReader) string {
+	switch reader.Property.Type {
+	case pst.PropertyTypeString:
+		value, _ := reader.GetString()
+		return value
+	case pst.PropertyTypeString8:
+		data := make([]byte, reader.Size())
+		if _, err := reader.ReadAt(data, 0); err != nil {
+			return ""
+		}
+		return strings.TrimRight(string(data), "\x00")
+	}
+	return ""
+}
+
+// readInt32Property reads a 32-bit integer property, returning 0 if missing
+func readInt32Property(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, propID uint16) int32 {
+	reader, err := propContext.GetPropertyReader(propID, localDescriptors)
+	if err != nil {
+		return 0
+	}
+	value, _ := reader.GetInteger32()
+	return value
+}
+
+// readBinaryProperty reads a binary property, returning nil if missing
+func readBinaryProperty(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, propID uint16) []byte {
+	reader, err := propContext.GetPropertyReader(propID, localDescriptors)
+	if err != nil || reader.HeapOnNodeReader == nil {
+		return nil
+	}
+	data := make([]byte, reader.Size())
+	if _, err := reader.ReadAt(data, 0); err != nil {
+		return nil
+	}
+	return data
+}
+
+// readTimeProperty reads a PT_SYSTIME property, returning the zero time if
+// missing or outside the range of plausible mail dates
+func readTimeProperty(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, propID uint16) time.Time {
+	reader, err := propContext.GetPropertyReader(propID, localDescriptors)
+	if err != nil {
+		return time.Time{}
+	}
+	nanos, err := reader.GetDate()
+	if err != nil {
+		return time.Time{}
+	}
+	t := time.Unix(0, nanos)
+	if t.Year() < 1990 || t.Year() > 2100 {
+		return time.Time{}
+	}
+	return t
+}
+
+// FolderTotal is the number and size of items in a folder, as found by Prescan
+type FolderTotal struct {
+	FolderInfo
+	Messages int
+	Bytes    int64
+}
+
+// Prescan counts the items and bytes in every folder Process would visit, in
+// the same order, without reading the items themselves. Counts and sizes come
+// from each folder's contents table, so this is fast even for large PSTs.
+func (e *Extractor) Prescan() ([]FolderTotal, error) {
+	if e.pstFile == nil {
+		return nil, fmt.Errorf("PST file not opened")
+	}
+
+	var totals []FolderTotal
+	err := e.walkFolders(func(folder *pst.Folder, info *FolderInfo) error {
+		if !info.Type.IsMail() {
+			return nil
+		}
+
+		total := FolderTotal{FolderInfo: *info}
+		if folder.MessageCount > 0 && folder.Identifier.GetType() != pst.IdentifierTypeSearchFolder {
+			total.Messages, total.Bytes = contentsTableTotals(folder)
+		}
+		totals = append(totals, total)
+		return nil
+	})
+	return totals, err
+}
+
+// contentsTableTotals returns the row count and summed PR_MESSAGE_SIZE of a
+// folder's contents table, falling back to the folder's item count if the
+// table can't be read
+func contentsTableTotals(folder *pst.Folder) (int, int64) {
+	file := folder.File
+	contentsIdentifier := folder.Identifier + 12 // NID of the folder's contents table
+
+	fallback := int(folder.MessageCount)
+	node, err := file.GetNodeBTreeNode(contentsIdentifier)
+	if err != nil {
+		return fallback, 0
+	}
+	localDescriptors, err := file.GetLocalDescriptors(node)
+	if err != nil {
+		return fallback, 0
+	}
+	dataNode, err := file.GetDataBTreeNode(contentsIdentifier)
+	if err != nil {
+		return fallback, 0
+	}
+	heapOnNode, err := file.GetHeapOnNode(dataNode)
+	if err != nil {
+		return fallback, 0
+	}
+	tableContext, err := file.GetTableContext(heapOnNode, localDescriptors, propMessageSize)
+	if err != nil {
+		return fallback, 0
+	}
+
+	var bytes int64
+	for _, row := range tableContext.Properties {
+		for _, property := range row {
+			reader, err := tableContext.GetPropertyReader(property, localDescriptors...)
+			if err != nil {
+				continue
+			}
+			if size, err := reader.GetInteger32(); err == nil && size > 0 {
+				bytes += int64(size)
+			}
+		}
+	}
+	return len(tableContext.Properties), bytes
+}
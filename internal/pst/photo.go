@@ -0,0 +1,150 @@
+package pst
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+
+	// Registered for image.Decode; Outlook saves pictures as JPEG, but
+	// pictures added by other programs may be PNG or GIF
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/emersion/go-vcard"
+	"github.com/mooijtech/go-pst/v6/pkg"
+)
+
+// contactPhotoName is the file name Outlook gives a contact's picture
+const contactPhotoName = "ContactPicture.jpg"
+
+// maxPhotoSize is the largest width or height of a contact photo; larger
+// pictures are scaled down to keep the vCard within server size limits
+const maxPhotoSize = 256
+
+// maxPhotoBytes is the largest contact picture attachment read; anything
+// bigger is not a real contact picture
+const maxPhotoBytes = 16 << 20
+
+// photoQuality is the JPEG quality contact photos are saved with
+const photoQuality = 85
+
+// contactPhoto returns a contact's picture as a JPEG of at most maxPhotoSize
+// pixels a side, or nil if it has none or it can't be decoded
+// The picture is the attachment flagged PR_ATTACHMENT_CONTACTPHOTO, or else
+// the one named ContactPicture.jpg.
+func contactPhoto(msg *pst.Message) []byte {
+	count, err := msg.GetAttachmentCount()
+	if err != nil || count == 0 {
+		return nil
+	}
+
+	var photo *pst.Attachment
+	for i := 0; i < count; i++ {
+		attachment, err := msg.GetAttachment(i)
+		if err != nil {
+			continue
+		}
+		if attachment.GetAttachmentContactPhoto() {
+			photo = attachment
+			break
+		}
+		if photo == nil && (strings.EqualFold(attachment.GetAttachLongFilename(), contactPhotoName) ||
+			strings.EqualFold(attachment.GetAttachFilename(), contactPhotoName)) {
+			photo = attachment
+		}
+	}
+	if photo == nil || photo.GetAttachSize() > maxPhotoBytes {
+		return nil
+	}
+
+	var data bytes.Buffer
+	if _, err := photo.WriteTo(&data); err != nil || data.Len() == 0 {
+		return nil
+	}
+	return normalizePhoto(data.Bytes())
+}
+
+// normalizePhoto decodes a picture and re-encodes it as a JPEG no larger than
+// maxPhotoSize a side
+// A JPEG that is already small enough is returned as it is.
+func normalizePhoto(data []byte) []byte {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if format == "jpeg" && config.Width <= maxPhotoSize && config.Height <= maxPhotoSize {
+		return data
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	img = scaleDown(img, maxPhotoSize)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: photoQuality}); err != nil {
+		return nil
+	}
+	return out.Bytes()
+}
+
+// scaleDown shrinks an image to fit within size pixels a side, keeping its
+// aspect ratio, by averaging the source pixels behind each new one
+// Transparent areas become white, as JPEG has no transparency.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newWidth, newHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			newWidth, newHeight = size, max(1, height*size/width)
+		} else {
+			newWidth, newHeight = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0 := bounds.Min.Y + y*height/newHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/newHeight)
+		for x := 0; x < newWidth; x++ {
+			x0 := bounds.Min.X + x*width/newWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/newWidth)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					// Blend onto white
+					white := 0xffff - uint64(pa)
+					r += uint64(pr) + white
+					g += uint64(pg) + white
+					b += uint64(pb) + white
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// addPhoto adds a JPEG photo to a vCard as an inline base64 PHOTO
+func addPhoto(card vcard.Card, photo []byte) {
+	card.Set(vcard.FieldPhoto, &vcard.Field{
+		Value: base64.StdEncoding.EncodeToString(photo),
+		Params: vcard.Params{
+			"ENCODING":      {"b"},
+			vcard.ParamType: {"JPEG"},
+		},
+	})
+}
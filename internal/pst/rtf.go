@@ -0,0 +1,508 @@
+package pst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// propRTFCompressed is PR_RTF_COMPRESSED, the message body as compressed RTF
+const propRTFCompressed = 0x1009
+
+// rtfPrebuf is the dictionary LZFu compression starts with
+// See MS-OXRTFCP section 2.1.2.1
+const rtfPrebuf = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}" +
+	"{\\f0\\fnil \\froman \\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier" +
+	"{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+// Compression types in the header of a compressed RTF body
+const (
+	rtfCompressed   = 0x75465a4c // "LZFu"
+	rtfUncompressed = 0x414c454d // "MELA"
+)
+
+// maxRTFSize is the largest decompressed RTF body accepted
+const maxRTFSize = 64 << 20
+
+// errBadRTF is returned for a compressed RTF body that can't be read
+var errBadRTF = errors.New("malformed compressed RTF")
+
+// decompressRTF decompresses a PR_RTF_COMPRESSED value (MS-OXRTFCP)
+func decompressRTF(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errBadRTF
+	}
+	rawSize := int(binary.LittleEndian.Uint32(data[4:]))
+	compType := binary.LittleEndian.Uint32(data[8:])
+	if rawSize > maxRTFSize {
+		return nil, fmt.Errorf("%w: %d bytes", errBadRTF, rawSize)
+	}
+	data = data[16:]
+
+	switch compType {
+	case rtfUncompressed:
+		return data[:min(rawSize, len(data))], nil
+	case rtfCompressed:
+	default:
+		return nil, fmt.Errorf("%w: unknown compression type %#x", errBadRTF, compType)
+	}
+
+	var dict [4096]byte
+	copy(dict[:], rtfPrebuf)
+	writePos := len(rtfPrebuf)
+
+	out := make([]byte, 0, rawSize)
+	for pos := 0; pos < len(data); {
+		control := data[pos]
+		pos++
+		for bit := 0; bit < 8 && pos < len(data); bit++ {
+			if control&(1<<bit) == 0 {
+				// Literal byte
+				out = append(out, data[pos])
+				dict[writePos] = data[pos]
+				writePos = (writePos + 1) % len(dict)
+				pos++
+				continue
+			}
+
+			// Reference into the dictionary: 12-bit offset, 4-bit length
+			if pos+2 > len(data) {
+				return nil, errBadRTF
+			}
+			ref := int(binary.BigEndian.Uint16(data[pos:]))
+			pos += 2
+			offset, length := ref>>4, ref&0xf+2
+			if offset == writePos {
+				return out, nil // End of the stream
+			}
+			for i := 0; i < length; i++ {
+				b := dict[(offset+i)%len(dict)]
+				out = append(out, b)
+				dict[writePos] = b
+				writePos = (writePos + 1) % len(dict)
+			}
+			if len(out) > maxRTFSize {
+				return nil, errBadRTF
+			}
+		}
+	}
+	return out, nil
+}
+
+// rtfBodies converts a compressed RTF body to the plain text and HTML bodies
+// of a message
+// HTML encapsulated in RTF (\fromhtml) is recovered as it was; text
+// (\fromtext) and native RTF are converted to text, and native RTF to HTML
+// as well, keeping bold, italic, underline and links.
+func rtfBodies(compressed []byte) (text, htmlBody string) {
+	rtf, err := decompressRTF(compressed)
+	if err != nil || !bytes.HasPrefix(bytes.TrimSpace(rtf), []byte("{\\rtf")) {
+		return "", ""
+	}
+
+	header := rtf[:min(len(rtf), 1024)]
+	switch {
+	case bytes.Contains(header, []byte("\\fromhtml")):
+		return "", newRTFReader(rtf, rtfModeHTML).convert()
+	case bytes.Contains(header, []byte("\\fromtext")):
+		return newRTFReader(rtf, rtfModeText).convert(), ""
+	default:
+		text = newRTFReader(rtf, rtfModeText).convert()
+		if strings.TrimSpace(text) == "" {
+			return "", ""
+		}
+		return text, newRTFReader(rtf, rtfModeNativeHTML).convert()
+	}
+}
+
+// What an rtfReader produces
+const (
+	rtfModeText       = iota // Plain text
+	rtfModeHTML              // The HTML encapsulated by \fromhtml
+	rtfModeNativeHTML        // HTML converted from native RTF
+)
+
+// rtfSkipped lists the destinations whose text isn't part of the body
+var rtfSkipped = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true,
+	"pict": true, "object": true, "header": true, "headerl": true,
+	"headerr": true, "headerf": true, "footer": true, "footerl": true,
+	"footerr": true, "footerf": true, "footnote": true, "listtable": true,
+	"listoverridetable": true, "rsidtbl": true, "xmlnstbl": true,
+	"themedata": true, "colorschememapping": true, "latentstyles": true,
+	"datastore": true, "generator": true, "filetbl": true, "revtbl": true,
+	"pgdsctbl": true, "nonshppict": true,
+}
+
+// rtfSymbols maps control words that stand for a character to it
+var rtfSymbols = map[string]string{
+	"par": "\n", "line": "\n", "sect": "\n", "page": "\n", "row": "\n",
+	"tab": "\t", "cell": "\t", "nestcell": "\t",
+	"emdash": "\u2014", "endash": "\u2013", "emspace": "\u2003", "enspace": "\u2002",
+	"qmspace": "\u2005", "bullet": "\u2022", "lquote": "\u2018", "rquote": "\u2019",
+	"ldblquote": "\u201c", "rdblquote": "\u201d", "zwj": "\u200d", "zwnj": "\u200c",
+}
+
+// rtfCharsets maps RTF font character sets (\fcharset) to code pages
+var rtfCharsets = map[int]int{
+	2: 1252, 77: 10000, 128: 932, 129: 949, 134: 936, 136: 950,
+	161: 1253, 162: 1254, 163: 1258, 177: 1255, 178: 1256, 186: 1257,
+	204: 1251, 222: 874, 238: 1250, 255: 437,
+}
+
+// rtfHyperlink finds the target of a HYPERLINK field instruction
+var rtfHyperlink = regexp.MustCompile(`HYPERLINK\s+(?:\\l\s+)?"([^"]*)"`)
+
+// rtfState is the part of the reader state saved and restored by groups
+type rtfState struct {
+	skip      bool // In a destination that isn't output
+	htmlRTF   bool // Between \htmlrtf and \htmlrtf0: RTF-only formatting
+	htmlTag   bool // In an \*\htmltag destination: encapsulated HTML
+	hidden    bool // \v hidden text
+	fontTable bool // In the font table
+	fldinst   bool // In a field instruction
+	font      int
+	uc        int // Characters to skip after \u
+	bold      bool
+	italic    bool
+	underline bool
+	link      string // Target, in the result of a HYPERLINK field
+}
+
+// rtfReader converts an RTF document to text or HTML
+type rtfReader struct {
+	data  []byte
+	pos   int
+	mode  int
+	state rtfState
+	stack []rtfState
+
+	out     strings.Builder
+	pending []byte // Bytes in the current code page, not yet decoded
+	skipN   int    // Fallback characters still to skip after \u
+
+	codePage     int
+	fontCodePage map[int]int
+	fontDef      int    // Font being defined in the font table
+	instruction  string // Last field instruction, for HYPERLINK
+
+	// HTML formatting currently open in the output
+	open rtfState
+}
+
+func newRTFReader(data []byte, mode int) *rtfReader {
+	return &rtfReader{
+		data:         data,
+		mode:         mode,
+		state:        rtfState{uc: 1},
+		codePage:     codePageWindows1252,
+		fontCodePage: make(map[int]int),
+	}
+}
+
+// convert reads the whole document and returns the converted body
+func (r *rtfReader) convert() string {
+	for r.pos < len(r.data) {
+		c := r.data[r.pos]
+		r.pos++
+		switch c {
+		case '{':
+			r.flush()
+			r.stack = append(r.stack, r.state)
+		case '}':
+			r.flush()
+			if len(r.stack) > 0 {
+				r.state = r.stack[len(r.stack)-1]
+				r.stack = r.stack[:len(r.stack)-1]
+			}
+		case '\\':
+			r.control()
+		case '\r', '\n':
+			// Line breaks in the source are not part of the text
+		default:
+			r.byteChar(c)
+		}
+	}
+	r.flush()
+
+	switch r.mode {
+	case rtfModeText:
+		return strings.TrimRight(strings.ReplaceAll(r.out.String(), "\n", "\r\n"), " \t\r\n") + "\r\n"
+	case rtfModeNativeHTML:
+		r.format(rtfState{})
+		return "<html><head><meta charset=\"utf-8\"></head><body>\r\n" +
+			strings.ReplaceAll(r.out.String(), "\n", "<br>\r\n") + "</body></html>\r\n"
+	}
+	return strings.ReplaceAll(r.out.String(), "\n", "\r\n")
+}
+
+// control reads a control word or symbol after a backslash
+func (r *rtfReader) control() {
+	if r.pos >= len(r.data) {
+		return
+	}
+	c := r.data[r.pos]
+	if !isASCIILetter(c) {
+		r.pos++
+		r.symbol(c)
+		return
+	}
+
+	start := r.pos
+	for r.pos < len(r.data) && isASCIILetter(r.data[r.pos]) {
+		r.pos++
+	}
+	word := string(r.data[start:r.pos])
+
+	param, hasParam := 0, false
+	negative := r.pos < len(r.data) && r.data[r.pos] == '-'
+	if negative {
+		r.pos++
+	}
+	for r.pos < len(r.data) && r.data[r.pos] >= '0' && r.data[r.pos] <= '9' {
+		param = param*10 + int(r.data[r.pos]-'0')
+		hasParam = true
+		r.pos++
+		if param > 1<<24 {
+			param = 1 << 24
+		}
+	}
+	if negative {
+		param = -param
+	}
+	if r.pos < len(r.data) && r.data[r.pos] == ' ' {
+		r.pos++
+	}
+
+	r.word(word, param, hasParam)
+}
+
+// symbol handles a control symbol: a backslash and one non-letter
+func (r *rtfReader) symbol(c byte) {
+	switch c {
+	case '\'':
+		// A byte in the current code page, as two hex digits
+		if r.pos+2 <= len(r.data) {
+			if b, err := strconv.ParseUint(string(r.data[r.pos:r.pos+2]), 16, 8); err == nil {
+				r.pos += 2
+				r.byteChar(byte(b))
+			}
+		}
+	case '*':
+		// An ignorable destination; htmltag is read when recovering HTML
+		if r.mode == rtfModeHTML && bytes.HasPrefix(r.data[r.pos:], []byte("\\htmltag")) {
+			return
+		}
+		if bytes.HasPrefix(r.data[r.pos:], []byte("\\fldinst")) {
+			return
+		}
+		r.state.skip = true
+	case '\r', '\n':
+		r.text("\n")
+	case '~':
+		r.text("\u00a0") // Non-breaking space
+	case '_':
+		r.text("\u2011") // Non-breaking hyphen
+	case '-':
+		// Optional hyphen
+	default:
+		r.byteChar(c) // \\, \{ and \}
+	}
+}
+
+// word handles a control word
+func (r *rtfReader) word(word string, param int, hasParam bool) {
+	on := !hasParam || param != 0
+
+	switch word {
+	case "ansicpg":
+		r.codePage = param
+	case "mac":
+		r.codePage = 10000
+	case "pc":
+		r.codePage = 437
+	case "pca":
+		r.codePage = 850
+	case "f":
+		r.flush()
+		if r.state.fontTable {
+			r.fontDef = param
+		} else {
+			r.state.font = param
+		}
+	case "fcharset":
+		if cp, ok := rtfCharsets[param]; ok && r.state.fontTable {
+			r.fontCodePage[r.fontDef] = cp
+		}
+	case "cpg":
+		if r.state.fontTable {
+			r.fontCodePage[r.fontDef] = param
+		}
+	case "uc":
+		r.state.uc = param
+	case "u":
+		if param < 0 {
+			param += 0x10000
+		}
+		r.text(string(rune(param)))
+		r.skipN = r.state.uc
+	case "bin":
+		// Binary data: never text
+		r.flush()
+		r.pos = min(r.pos+max(param, 0), len(r.data))
+	case "htmlrtf":
+		r.flush()
+		r.state.htmlRTF = on
+	case "htmltag":
+		r.flush()
+		r.state.htmlTag = true
+	case "mhtmltag":
+		r.state.skip = true
+	case "fldinst":
+		r.state.fldinst = true
+		r.instruction = ""
+	case "fldrslt":
+		if m := rtfHyperlink.FindStringSubmatch(r.instruction); m != nil {
+			r.state.link = m[1]
+		}
+	case "fonttbl":
+		r.state.fontTable = true
+		r.state.skip = true
+	case "plain":
+		r.state.bold, r.state.italic, r.state.underline, r.state.hidden = false, false, false, false
+	case "b":
+		r.state.bold = on
+	case "i":
+		r.state.italic = on
+	case "ul":
+		r.state.underline = on
+	case "ulnone":
+		r.state.underline = false
+	case "v":
+		r.state.hidden = on
+	default:
+		if rtfSkipped[word] {
+			r.state.skip = true
+		} else if symbol, ok := rtfSymbols[word]; ok {
+			r.text(symbol)
+		}
+	}
+}
+
+// byteChar handles one byte of text in the current code page
+func (r *rtfReader) byteChar(b byte) {
+	if r.skipN > 0 {
+		r.skipN--
+		return
+	}
+	if !r.visible() {
+		if r.state.fldinst && !r.state.skip {
+			r.pending = append(r.pending, b)
+		}
+		return
+	}
+	r.pending = append(r.pending, b)
+}
+
+// text writes text that is already decoded
+func (r *rtfReader) text(s string) {
+	if r.skipN > 0 {
+		// A fallback character written as a control word
+		r.skipN--
+		return
+	}
+	r.flush()
+	if r.state.fldinst && !r.state.skip {
+		r.instruction += s
+		return
+	}
+	if !r.visible() {
+		return
+	}
+	r.write(s)
+}
+
+// visible reports whether text in the current state is part of the body
+func (r *rtfReader) visible() bool {
+	if r.state.skip || r.state.fldinst {
+		return false
+	}
+	if r.mode == rtfModeHTML {
+		return r.state.htmlTag || !r.state.htmlRTF
+	}
+	return !r.state.hidden
+}
+
+// flush decodes the bytes waiting in the current code page
+func (r *rtfReader) flush() {
+	if len(r.pending) == 0 {
+		return
+	}
+	codePage := r.codePage
+	if cp, ok := r.fontCodePage[r.state.font]; ok {
+		codePage = cp
+	}
+	s := decodeCodePage(r.pending, codePage)
+	r.pending = r.pending[:0]
+
+	if r.state.fldinst && !r.state.skip {
+		r.instruction += s
+		return
+	}
+	r.write(s)
+}
+
+// write appends decoded text to the output, escaped and formatted for HTML
+// converted from native RTF
+func (r *rtfReader) write(s string) {
+	if r.mode != rtfModeNativeHTML {
+		r.out.WriteString(s)
+		return
+	}
+	r.format(r.state)
+	r.out.WriteString(html.EscapeString(s))
+}
+
+// format opens and closes HTML tags so the output matches a state's bold,
+// italic, underline and link
+func (r *rtfReader) format(state rtfState) {
+	open := r.open
+	if open.link == state.link && open.bold == state.bold && open.italic == state.italic && open.underline == state.underline {
+		return
+	}
+	// Close everything and reopen what is still on, keeping tags nested
+	if open.underline {
+		r.out.WriteString("</u>")
+	}
+	if open.italic {
+		r.out.WriteString("</i>")
+	}
+	if open.bold {
+		r.out.WriteString("</b>")
+	}
+	if open.link != "" && open.link != state.link {
+		r.out.WriteString("</a>")
+	}
+	if state.link != "" && state.link != open.link {
+		r.out.WriteString(`<a href="` + html.EscapeString(state.link) + `">`)
+	}
+	if state.bold {
+		r.out.WriteString("<b>")
+	}
+	if state.italic {
+		r.out.WriteString("<i>")
+	}
+	if state.underline {
+		r.out.WriteString("<u>")
+	}
+	r.open = state
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
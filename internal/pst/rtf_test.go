@@ -0,0 +1,71 @@
+package pst
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecompressRTF(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			// The compressed example from MS-OXRTFCP
+			name: "compressed",
+			data: []byte{
+				0x2d, 0x00, 0x00, 0x00, 0x2b, 0x00, 0x00, 0x00, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5, 0xc7, 0xa7,
+				0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42, 0x32, 0x0a, 0xf3, 0x20,
+				0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0, 0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f,
+				0xa0,
+			},
+			want: "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n",
+		},
+		{
+			// The MS-OXRTFCP example of a reference that reads what it writes
+			name: "compressed with overlapping reference",
+			data: []byte{
+				0x1a, 0x00, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x00, 0x4c, 0x5a, 0x46, 0x75, 0xe2, 0xd4, 0x4b, 0x51,
+				0x41, 0x00, 0x04, 0x20, 0x57, 0x58, 0x59, 0x5a, 0x0d, 0x6e, 0x7d, 0x01, 0x0e, 0xb0,
+			},
+			want: "{\\rtf1 WXYZWXYZWXYZWXYZWXYZ}",
+		},
+		{
+			name: "uncompressed",
+			data: append([]byte{
+				0x2e, 0x00, 0x00, 0x00, 0x22, 0x00, 0x00, 0x00, 0x4d, 0x45, 0x4c, 0x41, 0x00, 0x00, 0x00, 0x00,
+			}, "{\\rtf1\\ansi\\ansicpg1252\\pard test}"...),
+			want: "{\\rtf1\\ansi\\ansicpg1252\\pard test}",
+		},
+	} {
+		got, err := decompressRTF(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecompressRTFMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"short header", []byte{0x2d, 0x00, 0x00, 0x00, 0x2b, 0x00, 0x00, 0x00}},
+		{"unknown type", []byte{
+			0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x41, 0x42, 0x43, 0x44, 0x00, 0x00, 0x00, 0x00,
+		}},
+		{"truncated reference", []byte{
+			0x11, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x4c, 0x5a, 0x46, 0x75, 0x00, 0x00, 0x00, 0x00,
+			0x01, 0x00,
+		}},
+	} {
+		if _, err := decompressRTF(test.data); !errors.Is(err, errBadRTF) {
+			t.Errorf("%s: got %v, want %v", test.name, err, errBadRTF)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package pst
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Folder skip reasons given by FolderRules
+const (
+	SkipExcluded    = "excluded"
+	SkipNotIncluded = "not included"
+	SkipDrafts      = "drafts (use --include-drafts)"
+	SkipJunk        = "junk (use --include-junk)"
+)
+
+// FolderRules decides which folders are imported, by full folder path
+// (e.g. "Top of Personal Folders/Inbox/Projects") and folder type
+//
+// Patterns are globs matched against the whole path, ignoring case, where *
+// and ? stay within one folder name and ** spans any number of folders
+// ("**/Inbox/**" matches Inbox and everything below it). A pattern starting
+// with "re:" is a regular expression instead, matched unanchored.
+type FolderRules struct {
+	Include []string // If set, only folders matching one of these are imported
+	Exclude []string // Folders matching any of these are skipped
+
+	SkipDeleted bool // Skip Deleted Items, and its subfolders
+	SkipSent    bool // Skip Sent Items, and its subfolders
+
+	// Drafts and Junk E-mail are skipped unless asked for
+	IncludeDrafts bool
+	IncludeJunk   bool
+
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	compiled bool
+}
+
+// Compile checks and compiles the patterns
+// SkipReason compiles on first use, but only Compile reports bad patterns
+func (r *FolderRules) Compile() error {
+	var err error
+	if r.include, err = compilePatterns(r.Include); err != nil {
+		return err
+	}
+	if r.exclude, err = compilePatterns(r.Exclude); err != nil {
+		return err
+	}
+	r.compiled = true
+	return nil
+}
+
+// SkipReason returns why a folder is skipped, or "" to import it
+func (r *FolderRules) SkipReason(folder *FolderInfo) string {
+	if !r.compiled {
+		r.Compile()
+	}
+
+	for i, re := range r.exclude {
+		if re.MatchString(folder.Path) {
+			return fmt.Sprintf("%s by %q", SkipExcluded, r.Exclude[i])
+		}
+	}
+	if len(r.include) > 0 && !matchesAny(r.include, folder.Path) {
+		return SkipNotIncluded
+	}
+
+	// Deleted Items and Sent Items with everything inside them, Drafts and
+	// Junk E-mail on their own
+	if r.SkipDeleted && (folder.Type == FolderDeleted || folder.Within == FolderDeleted) {
+		return "--skip-deleted"
+	}
+	if r.SkipSent && (folder.Type == FolderSent || folder.Within == FolderSent) {
+		return "--skip-sent"
+	}
+	if !r.IncludeDrafts && folder.Type == FolderDrafts {
+		return SkipDrafts
+	}
+	if !r.IncludeJunk && folder.Type == FolderJunk {
+		return SkipJunk
+	}
+	return ""
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles glob or "re:" patterns to case-insensitive regexps
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr, ok := strings.CutPrefix(pattern, "re:")
+		if !ok {
+			expr = globToRegexp(pattern)
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid folder pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// globToRegexp converts a folder glob to an anchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?") // Zero or more whole folders
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("(?:/.*)?") // The folder itself or anything below
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,321 @@
+package pst
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/mooijtech/go-pst/v6/pkg"
+)
+
+// FolderType is what kind of folder a FolderInfo describes
+type FolderType string
+
+// Folder types. The well-known mail folders are found through the entry IDs
+// the PST stores for them, other types by the folder's container class, and
+// only if neither is present by English folder name.
+const (
+	FolderMail     FolderType = "mail" // Any other mail folder
+	FolderInbox    FolderType = "inbox"
+	FolderSent     FolderType = "sent"
+	FolderDeleted  FolderType = "deleted"
+	FolderDrafts   FolderType = "drafts"
+	FolderJunk     FolderType = "junk"
+	FolderOutbox   FolderType = "outbox"
+	FolderCalendar FolderType = "calendar"
+	FolderContacts FolderType = "contacts"
+	FolderTasks    FolderType = "tasks"
+	FolderNotes    FolderType = "notes"
+	FolderJournal  FolderType = "journal"
+	FolderOther    FolderType = "other" // Non-mail folders of any other kind, e.g. Sync Issues or RSS Feeds
+)
+
+// IsMail reports whether folders of this type hold mail to import
+// The Outbox holds mail that was never sent, so it doesn't count.
+func (t FolderType) IsMail() bool {
+	switch t {
+	case FolderMail, FolderInbox, FolderSent, FolderDeleted, FolderDrafts, FolderJunk:
+		return true
+	}
+	return false
+}
+
+// Property IDs locating special folders
+// See MS-PST 2.4.3 and MS-OXOSFLD
+const (
+	propIPMSubtreeEntryID     = 0x35E0 // PidTagIpmSubtreeEntryId, on the message store
+	propIPMOutboxEntryID      = 0x35E2 // PidTagIpmOutboxEntryId
+	propIPMWastebasketEntryID = 0x35E3 // PidTagIpmWastebasketEntryId
+	propIPMSentMailEntryID    = 0x35E4 // PidTagIpmSentMailEntryId
+
+	propIPMAppointmentEntryID = 0x36D0 // PidTagIpmAppointmentEntryId, on the root folder and Inbox
+	propIPMContactEntryID     = 0x36D1 // PidTagIpmContactEntryId
+	propIPMJournalEntryID     = 0x36D2 // PidTagIpmJournalEntryId
+	propIPMNoteEntryID        = 0x36D3 // PidTagIpmNoteEntryId
+	propIPMTaskEntryID        = 0x36D4 // PidTagIpmTaskEntryId
+	propIPMDraftsEntryID      = 0x36D7 // PidTagIpmDraftsEntryId
+	propAdditionalRenEntryIDs = 0x36D8 // PidTagAdditionalRenEntryIds: conflicts, sync issues, local failures, server failures, junk
+
+	propContainerClass = 0x3613 // PidTagContainerClass, e.g. "IPF.Note"
+)
+
+// specialFolders maps the folder IDs named by the store's special-folder
+// entry IDs to their type
+// The Inbox has no entry ID of its own; it is the folder in the IPM subtree
+// that, like the root folder, carries the calendar and contacts entry IDs.
+type specialFolders map[pst.Identifier]FolderType
+
+// specialFolders returns the PST's special folders, reading them on first use
+func (e *Extractor) specialFolders() specialFolders {
+	if e.special == nil {
+		e.special = readSpecialFolders(e.pstFile)
+	}
+	return e.special
+}
+
+// readSpecialFolders reads the special-folder entry IDs of a PST
+// A PST without them yields an empty map, and folders are typed by name.
+func readSpecialFolders(file *pst.File) specialFolders {
+	special := make(specialFolders)
+
+	store, err := file.GetMessageStore()
+	if err != nil {
+		return special
+	}
+	for propID, folderType := range map[uint16]FolderType{
+		propIPMOutboxEntryID:      FolderOutbox,
+		propIPMWastebasketEntryID: FolderDeleted,
+		propIPMSentMailEntryID:    FolderSent,
+	} {
+		if id, ok := entryIDProperty(store, nil, propID); ok {
+			special[id] = folderType
+		}
+	}
+
+	// The entry IDs on the root folder and Inbox
+	containers := []pst.Identifier{pst.IdentifierRootFolder}
+	subtree, ok := entryIDProperty(store, nil, propIPMSubtreeEntryID)
+	if ok {
+		containers = append(containers, subtree)
+	}
+	for _, id := range containers {
+		special.addFolderEntryIDs(file, id)
+	}
+
+	if ok {
+		// Find the Inbox among the top-level mail folders
+		ipmSubtree := pst.Folder{Identifier: subtree, HasSubFolders: true, File: file}
+		subFolders, _ := ipmSubtree.GetSubFolders()
+		for _, folder := range subFolders {
+			propContext, localDescriptors, err := folderPropertyContext(file, folder.Identifier)
+			if err != nil {
+				continue
+			}
+			if _, isInbox := entryIDProperty(propContext, localDescriptors, propIPMAppointmentEntryID); isInbox {
+				special[folder.Identifier] = FolderInbox
+				special.addFolderEntryIDs(file, folder.Identifier)
+				break
+			}
+		}
+	}
+
+	return special
+}
+
+// addFolderEntryIDs records the special folders named by a folder's
+// properties, which are set on the root folder and the Inbox
+func (s specialFolders) addFolderEntryIDs(file *pst.File, identifier pst.Identifier) {
+	propContext, localDescriptors, err := folderPropertyContext(file, identifier)
+	if err != nil {
+		return
+	}
+	for propID, folderType := range map[uint16]FolderType{
+		propIPMAppointmentEntryID: FolderCalendar,
+		propIPMContactEntryID:     FolderContacts,
+		propIPMJournalEntryID:     FolderJournal,
+		propIPMNoteEntryID:        FolderNotes,
+		propIPMTaskEntryID:        FolderTasks,
+		propIPMDraftsEntryID:      FolderDrafts,
+	} {
+		if id, ok := entryIDProperty(propContext, localDescriptors, propID); ok {
+			s[id] = folderType
+		}
+	}
+
+	reader, err := propContext.GetPropertyReader(propAdditionalRenEntryIDs, localDescriptors)
+	if err != nil {
+		return
+	}
+	data := make([]byte, reader.Size())
+	if _, err := reader.ReadAt(data, 0); err != nil {
+		return
+	}
+	for i, entryID := range multiValueBinary(data) {
+		id, ok := entryIDFolder(entryID)
+		if !ok {
+			continue
+		}
+		if i == 4 {
+			s[id] = FolderJunk
+		} else if _, known := s[id]; !known {
+			s[id] = FolderOther // Conflicts, Sync Issues, Local and Server Failures
+		}
+	}
+}
+
+// folderPropertyContext reads the property context of a folder
+func folderPropertyContext(file *pst.File, identifier pst.Identifier) (*pst.PropertyContext, []pst.LocalDescriptor, error) {
+	dataNode, err := file.GetDataBTreeNode(identifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	heapOnNode, err := file.GetHeapOnNode(dataNode)
+	if err != nil {
+		return nil, nil, err
+	}
+	propContext, err := file.GetPropertyContext(heapOnNode)
+	if err != nil {
+		return nil, nil, err
+	}
+	var localDescriptors []pst.LocalDescriptor
+	if node, err := file.GetNodeBTreeNode(identifier); err == nil {
+		localDescriptors, _ = file.GetLocalDescriptors(node)
+	}
+	return propContext, localDescriptors, nil
+}
+
+// entryIDProperty reads a binary entry ID property and returns the folder it
+// names
+func entryIDProperty(propContext *pst.PropertyContext, localDescriptors []pst.LocalDescriptor, propID uint16) (pst.Identifier, bool) {
+	reader, err := propContext.GetPropertyReader(propID, localDescriptors)
+	if err != nil {
+		return 0, false
+	}
+	data := make([]byte, reader.Size())
+	if _, err := reader.ReadAt(data, 0); err != nil {
+		return 0, false
+	}
+	return entryIDFolder(data)
+}
+
+// entryIDFolder returns the node ID in a PST entry ID: 4 flag bytes, the
+// store's 16-byte UID, then the NID (MS-PST 2.2.2.3)
+func entryIDFolder(entryID []byte) (pst.Identifier, bool) {
+	if len(entryID) != 24 {
+		return 0, false
+	}
+	id := binary.LittleEndian.Uint32(entryID[20:])
+	return pst.Identifier(id), id != 0
+}
+
+// multiValueBinary splits a PT_MV_BINARY value into its items: a count, an
+// offset for each item, then the item data (MS-PST 2.3.3.4.2)
+func multiValueBinary(data []byte) [][]byte {
+	if len(data) < 4 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	if count <= 0 || 4+4*count > len(data) {
+		return nil
+	}
+	offsets := make([]int, count+1)
+	for i := 0; i < count; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(data[4+4*i:]))
+	}
+	offsets[count] = len(data)
+
+	items := make([][]byte, count)
+	for i := range items {
+		start, end := offsets[i], offsets[i+1]
+		if start < 0 || start > end || end > len(data) {
+			return nil
+		}
+		items[i] = data[start:end]
+	}
+	return items
+}
+
+// classFolderTypes maps container class prefixes to folder types
+var classFolderTypes = []struct {
+	prefix     string
+	folderType FolderType
+}{
+	{"ipf.appointment", FolderCalendar},
+	{"ipf.contact", FolderContacts},
+	{"ipf.task", FolderTasks},
+	{"ipf.stickynote", FolderNotes},
+	{"ipf.journal", FolderJournal},
+}
+
+// classFolderType returns the folder type for a PR_CONTAINER_CLASS
+// An empty class gives "", as the class doesn't tell.
+func classFolderType(class string) FolderType {
+	lowerClass := strings.ToLower(class)
+	switch lowerClass {
+	case "":
+		return ""
+	case "ipf", "ipf.note", "ipf.imap":
+		return FolderMail
+	}
+	for _, c := range classFolderTypes {
+		if strings.HasPrefix(lowerClass, c.prefix) {
+			return c.folderType
+		}
+	}
+	// Configuration, RSS feeds, social connector and other special content
+	return FolderOther
+}
+
+// nameFolderTypes gives the type of folders by English name, for PSTs that
+// don't record entry IDs or container classes
+var nameFolderTypes = map[string]FolderType{
+	"inbox":                FolderInbox,
+	"sent items":           FolderSent,
+	"sent":                 FolderSent,
+	"deleted items":        FolderDeleted,
+	"trash":                FolderDeleted,
+	"drafts":               FolderDrafts,
+	"junk e-mail":          FolderJunk,
+	"junk email":           FolderJunk,
+	"outbox":               FolderOutbox,
+	"calendar":             FolderCalendar,
+	"contacts":             FolderContacts,
+	"tasks":                FolderTasks,
+	"notes":                FolderNotes,
+	"journal":              FolderJournal,
+	"sync issues":          FolderOther,
+	"conflicts":            FolderOther,
+	"local failures":       FolderOther,
+	"server failures":      FolderOther,
+	"rss feeds":            FolderOther,
+	"conversation history": FolderOther,
+}
+
+// folderType decides the type of a folder from its ID, container class and name
+func (s specialFolders) folderType(identifier pst.Identifier, class, name string) FolderType {
+	if folderType, ok := s[identifier]; ok {
+		return folderType
+	}
+	classType := classFolderType(class)
+	if classType != "" && classType != FolderMail {
+		return classType
+	}
+	// A mail folder, or one whose class isn't known, may still be a
+	// well-known folder by name. Calendar, Contacts and the like always have
+	// their own class, so a mail folder of that name is just mail.
+	nameType := nameFolderTypes[strings.ToLower(name)]
+	if nameType == "" || (classType == FolderMail && hasOwnClass(nameType)) {
+		return FolderMail
+	}
+	return nameType
+}
+
+// hasOwnClass reports whether folders of a type have a container class other
+// than IPF.Note
+func hasOwnClass(folderType FolderType) bool {
+	for _, c := range classFolderTypes {
+		if c.folderType == folderType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package pst
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+)
+
+// vCard versions cards can be written in
+const (
+	VCard30 = "3.0" // RFC 2426, which every CardDAV server and phone reads
+	VCard40 = "4.0" // RFC 6350
+)
+
+// SetVCardVersion chooses the vCard version of the cards ProcessContacts
+// builds: VCard30 (the default) or VCard40
+func (e *Extractor) SetVCardVersion(version string) error {
+	switch version {
+	case "":
+		e.vcardVersion = VCard30
+	case VCard30, VCard40:
+		e.vcardVersion = version
+	default:
+		return fmt.Errorf("unknown vCard version %q (use %s or %s)", version, VCard30, VCard40)
+	}
+	return nil
+}
+
+// toVCard4 rewrites a vCard 3.0 card built from the PST as vCard 4.0
+//   - TYPE=PREF becomes PREF=1, and EMAIL's TYPE=INTERNET is dropped
+//   - Dates are written in the basic ISO 8601 format 4.0 requires (19850412)
+//   - X-ANNIVERSARY is dropped, as ANNIVERSARY is standard
+//   - X-SPOUSE becomes RELATED;TYPE=spouse;VALUE=text
+//   - An inline PHOTO becomes a data: URI
+func toVCard4(card vcard.Card) {
+	card.SetValue(vcard.FieldVersion, VCard40)
+
+	for name, fields := range card {
+		for _, field := range fields {
+			if types := field.Params[vcard.ParamType]; len(types) > 0 {
+				var kept []string
+				for _, t := range types {
+					switch {
+					case strings.EqualFold(t, "PREF"):
+						field.Params.Set(vcard.ParamPreferred, "1")
+					case strings.EqualFold(t, "INTERNET") && name == vcard.FieldEmail:
+					default:
+						kept = append(kept, t)
+					}
+				}
+				if len(kept) > 0 {
+					field.Params[vcard.ParamType] = kept
+				} else {
+					delete(field.Params, vcard.ParamType)
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{vcard.FieldBirthday, vcard.FieldAnniversary} {
+		for _, field := range card[name] {
+			field.Value = strings.ReplaceAll(field.Value, "-", "")
+		}
+	}
+	delete(card, "X-ANNIVERSARY")
+
+	if spouse := card.Value("X-SPOUSE"); spouse != "" {
+		card.Add(vcard.FieldRelated, &vcard.Field{
+			Value: spouse,
+			Params: vcard.Params{
+				vcard.ParamType:  {"spouse"},
+				vcard.ParamValue: {"text"},
+			},
+		})
+		delete(card, "X-SPOUSE")
+	}
+
+	for _, field := range card[vcard.FieldPhoto] {
+		if strings.EqualFold(field.Params.Get("ENCODING"), "b") {
+			mediaType := "image/" + strings.ToLower(field.Params.Get(vcard.ParamType))
+			field.Value = "data:" + mediaType + ";base64," + field.Value
+			field.Params = vcard.Params{}
+		}
+	}
+}
+
+// EncodeCard writes a card in vCard format
+// go-vcard escapes commas in every value, which breaks the data: URI of a
+// vCard 4.0 PHOTO, so the escaping is undone there.
+func EncodeCard(w io.Writer, card vcard.Card) error {
+	var buf bytes.Buffer
+	if err := vcard.NewEncoder(&buf).Encode(card); err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(buf.Bytes(), []byte("\r\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(line, []byte(vcard.FieldPhoto+":")) || bytes.HasPrefix(line, []byte(vcard.FieldPhoto+";")) {
+			lines[i] = bytes.ReplaceAll(line, []byte(`\,`), []byte(","))
+		}
+	}
+	_, err := w.Write(bytes.Join(lines, nil))
+	return err
+}
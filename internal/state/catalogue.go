@@ -0,0 +1,214 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+const (
+	// catalogueFile indexes every import known to a state directory
+	catalogueFile = "catalogue.json"
+	// catalogueVersion is bumped when the catalogue format changes
+	catalogueVersion = 1
+)
+
+// Store is a state directory holding one state file per PST/user/destination
+// combination, plus a catalogue indexing all of them
+type Store struct {
+	dir string
+	mu  sync.Mutex // Serializes catalogue read-modify-write cycles
+
+	// Encryption at rest, nil when disabled
+	sealer   *sealer
+	sealerMu sync.Mutex
+}
+
+// CatalogueEntry summarizes one import for the status listing
+type CatalogueEntry struct {
+	Key           string    `json:"key"`
+	PSTPath       string    `json:"pst_path"`
+	PSTHash       string    `json:"pst_hash"`
+	Username      string    `json:"username"`
+	Destination   string    `json:"destination"`
+	StateFile     string    `json:"state_file"` // Relative to the state directory
+	UploadedCount int       `json:"uploaded_count"`
+	TotalCount    int       `json:"total_count"`
+	FailedCount   int       `json:"failed_count"`
+	Complete      bool      `json:"complete"`
+	LastRun       time.Time `json:"last_run"`
+}
+
+// catalogue is the on-disk format of the catalogue file
+type catalogue struct {
+	Version int                       `json:"version"`
+	Imports map[string]CatalogueEntry `json:"imports"`
+}
+
+// DefaultDir returns the default state directory inside the user's config directory
+func DefaultDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "pst-import", "state"), nil
+}
+
+// NewStore opens a state directory, creating it if needed
+// An empty dir selects DefaultDir
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	if err := os.MkdirAll(absDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	return &Store{dir: absDir}, nil
+}
+
+// Dir returns the state directory
+func (st *Store) Dir() string {
+	return st.dir
+}
+
+// NewImportState creates a new import state for a PST file, user and destination server
+func (st *Store) NewImportState(pstPath, username, destination string) (*ImportState, error) {
+	absPath, err := filepath.Abs(pstPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	hash, err := hashPSTFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash PST file: %w", err)
+	}
+
+	key := catalogueKey(hash, username, destination)
+
+	state := &ImportState{
+		PSTPath:         absPath,
+		PSTHash:         hash,
+		Username:        username,
+		Destination:     destination,
+		CompletedFolder: make(map[string]bool),
+		Failed:          make(map[string]string),
+		bloomFilter:     bloom.NewWithEstimates(defaultBloomCapacity, defaultFalsePositiveRate),
+		store:           st,
+		key:             key,
+		statePath:       filepath.Join(st.dir, key+".json"),
+		legacyPath:      absPath + ".import-state.json",
+	}
+
+	return state, nil
+}
+
+// Entries returns every catalogued import, most recently run first
+func (st *Store) Entries() ([]CatalogueEntry, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cat, err := st.readCatalogue()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]CatalogueEntry, 0, len(cat.Imports))
+	for _, entry := range cat.Imports {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastRun.After(entries[j].LastRun)
+	})
+
+	return entries, nil
+}
+
+// updateEntry inserts or replaces a catalogue entry
+func (st *Store) updateEntry(entry CatalogueEntry) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cat, err := st.readCatalogue()
+	if err != nil {
+		return err
+	}
+	cat.Imports[entry.Key] = entry
+
+	return st.writeCatalogue(cat)
+}
+
+// removeEntry deletes a catalogue entry if present
+func (st *Store) removeEntry(key string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cat, err := st.readCatalogue()
+	if err != nil {
+		return err
+	}
+	if _, ok := cat.Imports[key]; !ok {
+		return nil
+	}
+	delete(cat.Imports, key)
+
+	return st.writeCatalogue(cat)
+}
+
+// readCatalogue loads the catalogue file. Must hold st.mu.
+func (st *Store) readCatalogue() (*catalogue, error) {
+	cat := &catalogue{Version: catalogueVersion, Imports: make(map[string]CatalogueEntry)}
+
+	data, err := st.readFile(filepath.Join(st.dir, catalogueFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cat, nil
+		}
+		if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrPlaintext) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read catalogue: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cat); err != nil {
+		return nil, fmt.Errorf("failed to parse catalogue: %w", err)
+	}
+	if cat.Imports == nil {
+		cat.Imports = make(map[string]CatalogueEntry)
+	}
+
+	return cat, nil
+}
+
+// writeCatalogue atomically replaces the catalogue file. Must hold st.mu.
+func (st *Store) writeCatalogue(cat *catalogue) error {
+	data, err := json.MarshalIndent(cat, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize catalogue: %w", err)
+	}
+	return st.writeFile(filepath.Join(st.dir, catalogueFile), data)
+}
+
+// catalogueKey identifies a PST/user/destination combination
+func catalogueKey(pstHash, username, destination string) string {
+	h := sha256.Sum256([]byte(pstHash + "\x00" + username + "\x00" + destination))
+	return hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,131 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ContactState records where each contact from a PST was stored on a CardDAV
+// server and its ETag there, so a later import only replaces copies nobody
+// has changed since
+// Unlike ImportState it is kept after an import finishes.
+type ContactState struct {
+	PSTPath     string                   `json:"pst_path"`
+	PSTHash     string                   `json:"pst_hash"`
+	Username    string                   `json:"username"`
+	Destination string                   `json:"destination"` // Address book URL
+	Contacts    map[string]ContactRecord `json:"contacts"`    // By contact UID
+	LastRun     time.Time                `json:"last_run"`
+
+	// Runtime fields (not serialized)
+	store *Store
+	path  string
+	mu    sync.Mutex
+}
+
+// ContactRecord is the server copy of one uploaded contact
+type ContactRecord struct {
+	Href   string `json:"href"` // Resource URL, which the server may have chosen
+	ETag   string `json:"etag"`
+	Merged bool   `json:"merged,omitempty"` // Merged into a contact already on the server
+}
+
+// NewContactState creates the contact state for a PST file, user and address book
+func (st *Store) NewContactState(pstPath, username, destination string) (*ContactState, error) {
+	absPath, err := filepath.Abs(pstPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	hash, err := hashPSTFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash PST file: %w", err)
+	}
+
+	key := catalogueKey(hash, username, destination)
+
+	return &ContactState{
+		PSTPath:     absPath,
+		PSTHash:     hash,
+		Username:    username,
+		Destination: destination,
+		Contacts:    make(map[string]ContactRecord),
+		store:       st,
+		path:        filepath.Join(st.dir, key+".contacts.json"),
+	}, nil
+}
+
+// Load loads the records saved by earlier imports, if any
+func (s *ContactState) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.store.readFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrPlaintext) {
+			return err
+		}
+		return fmt.Errorf("failed to read contact state: %w", err)
+	}
+
+	var loaded ContactState
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse contact state: %w", err)
+	}
+	if loaded.PSTHash != s.PSTHash || loaded.Username != s.Username || loaded.Destination != s.Destination {
+		return nil
+	}
+	if loaded.Contacts != nil {
+		s.Contacts = loaded.Contacts
+	}
+	return nil
+}
+
+// Save persists the records to disk
+func (s *ContactState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.LastRun = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to serialize contact state: %w", err)
+	}
+	return s.store.writeFile(s.path, data)
+}
+
+// ContactETag returns where a contact was stored, its ETag there and whether
+// it was merged into a contact already on the server
+func (s *ContactState) ContactETag(uid string) (href, etag string, merged, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.Contacts[uid]
+	return record.Href, record.ETag, record.Merged, ok
+}
+
+// SetContactETag records where a contact was stored and its new ETag
+// An empty href forgets the contact.
+func (s *ContactState) SetContactETag(uid, href, etag string, merged bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if href == "" {
+		delete(s.Contacts, uid)
+		return
+	}
+	s.Contacts[uid] = ContactRecord{Href: href, ETag: etag, Merged: merged}
+}
+
+// Path returns the path to the contact state file
+func (s *ContactState) Path() string {
+	return s.path
+}
@@ -0,0 +1,333 @@
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	// cipherAESGCM is the only cipher written to envelopes
+	cipherAESGCM = "aes-256-gcm"
+	// kdfPBKDF2 derives the key from a passphrase with PBKDF2-HMAC-SHA256
+	kdfPBKDF2 = "pbkdf2-sha256"
+	// kdfKeyring uses a random key held in the OS secret store
+	kdfKeyring = "keyring"
+
+	// pbkdf2Iterations follows the current OWASP recommendation for PBKDF2-HMAC-SHA256
+	pbkdf2Iterations = 600_000
+	// maxPBKDF2Iterations bounds the work a corrupted envelope can demand
+	maxPBKDF2Iterations = 10_000_000
+	saltSize            = 16
+	keySize             = 32
+
+	// Keyring entry holding the state key
+	keyringService = "pst-import"
+	keyringAccount = "state-encryption-key"
+)
+
+// ErrEncrypted is returned when reading an encrypted state file without a key
+var ErrEncrypted = errors.New("state is encrypted; enable state encryption to read it")
+
+// ErrPlaintext is returned when reading a plaintext state file with
+// encryption enabled, until EncryptExisting has encrypted it
+var ErrPlaintext = errors.New("state is not encrypted; run encrypt-state to encrypt it first")
+
+// ErrNoKey is returned by UseExistingKeyring when the OS secret store holds
+// no state key, so no state can have been saved with one
+var ErrNoKey = errors.New("no state key in the keyring")
+
+// envelope is the on-disk format of an encrypted state or catalogue file
+type envelope struct {
+	Encrypted  string `json:"encrypted"` // Cipher name
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt,omitempty"` // Base64, passphrase mode only
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      string `json:"nonce"` // Base64
+	Data       string `json:"data"`  // Base64 ciphertext
+}
+
+// sealer encrypts and decrypts files in a state directory
+type sealer struct {
+	kdf        string
+	passphrase string
+	salt       []byte                 // Salt used for new writes (passphrase mode)
+	aeads      map[string]cipher.AEAD // Derived ciphers by salt (passphrase mode)
+	keyringKey cipher.AEAD
+}
+
+// UsePassphrase enables encryption of state files with a key derived from passphrase
+func (st *Store) UsePassphrase(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("empty state passphrase")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	st.sealerMu.Lock()
+	defer st.sealerMu.Unlock()
+	st.sealer = &sealer{
+		kdf:        kdfPBKDF2,
+		passphrase: passphrase,
+		salt:       salt,
+		aeads:      make(map[string]cipher.AEAD),
+	}
+	return nil
+}
+
+// PassphraseEnv is the environment variable holding the passphrase for
+// passphrase encryption
+const PassphraseEnv = "PST_IMPORT_STATE_PASSPHRASE"
+
+// UseEncryption enables encryption by its name in the settings: "none" (or
+// ""), "passphrase", with the passphrase from $PST_IMPORT_STATE_PASSPHRASE,
+// or "keyring"
+// Without createKey a keyring key is never created, giving ErrNoKey instead.
+func (st *Store) UseEncryption(mode string, createKey bool) error {
+	switch mode {
+	case "", "none":
+		return nil
+	case "passphrase":
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			return fmt.Errorf("state encryption needs a passphrase in $%s", PassphraseEnv)
+		}
+		return st.UsePassphrase(passphrase)
+	case "keyring":
+		return st.useKeyring(createKey)
+	default:
+		return fmt.Errorf("unknown state encryption %q (use none, passphrase or keyring)", mode)
+	}
+}
+
+// UseKeyring enables encryption of state files with a random key held in the
+// OS secret store, creating the key on first use
+func (st *Store) UseKeyring() error {
+	return st.useKeyring(true)
+}
+
+// UseExistingKeyring is UseKeyring for reading state: it returns ErrNoKey
+// instead of creating a key
+func (st *Store) UseExistingKeyring() error {
+	return st.useKeyring(false)
+}
+
+// useKeyring reads the state key from the OS secret store, creating it if
+// there is none and create is set
+func (st *Store) useKeyring(create bool) error {
+	encoded, err := keyring.Get(keyringService, keyringAccount)
+	if errors.Is(err, keyring.ErrNotFound) {
+		if !create {
+			return ErrNoKey
+		}
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("failed to generate state key: %w", err)
+		}
+		encoded = base64.StdEncoding.EncodeToString(key)
+		if err := keyring.Set(keyringService, keyringAccount, encoded); err != nil {
+			return fmt.Errorf("failed to store state key in keyring: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read state key from keyring: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return fmt.Errorf("invalid state key in keyring")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	st.sealerMu.Lock()
+	defer st.sealerMu.Unlock()
+	st.sealer = &sealer{kdf: kdfKeyring, keyringKey: aead}
+	return nil
+}
+
+// writeFile atomically writes a state directory file, encrypting it if enabled
+func (st *Store) writeFile(path string, data []byte) error {
+	st.sealerMu.Lock()
+	if st.sealer != nil {
+		sealed, err := st.sealer.seal(filepath.Base(path), data)
+		if err != nil {
+			st.sealerMu.Unlock()
+			return err
+		}
+		data = sealed
+	}
+	st.sealerMu.Unlock()
+
+	return writeFileAtomic(path, data)
+}
+
+// readFile reads a state file, decrypting it if it is an envelope
+// With encryption enabled, plaintext files are rejected with ErrPlaintext
+// rather than read and then overwritten encrypted; see EncryptExisting.
+func (st *Store) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st.sealerMu.Lock()
+	defer st.sealerMu.Unlock()
+
+	var env envelope
+	if json.Unmarshal(data, &env) != nil || env.Encrypted == "" {
+		if st.sealer != nil {
+			return nil, ErrPlaintext
+		}
+		return data, nil
+	}
+
+	if st.sealer == nil {
+		return nil, ErrEncrypted
+	}
+	return st.sealer.open(filepath.Base(path), &env)
+}
+
+// EncryptExisting encrypts the plaintext files in the state directory,
+// returning how many it encrypted
+// Encryption must be enabled. Files already encrypted are left as they are.
+func (st *Store) EncryptExisting() (int, error) {
+	st.sealerMu.Lock()
+	enabled := st.sealer != nil
+	st.sealerMu.Unlock()
+	if !enabled {
+		return 0, fmt.Errorf("state encryption is not enabled")
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(st.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return count, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+		var env envelope
+		if json.Unmarshal(data, &env) == nil && env.Encrypted != "" {
+			continue
+		}
+		if err := st.writeFile(path, data); err != nil {
+			return count, fmt.Errorf("failed to encrypt %s: %w", filepath.Base(path), err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// seal encrypts data, binding it to the file name so files can't be swapped
+func (s *sealer) seal(name string, data []byte) ([]byte, error) {
+	env := envelope{Encrypted: cipherAESGCM, KDF: s.kdf}
+
+	aead := s.keyringKey
+	if s.kdf == kdfPBKDF2 {
+		var err error
+		if aead, err = s.derive(s.salt, pbkdf2Iterations); err != nil {
+			return nil, err
+		}
+		env.Salt = base64.StdEncoding.EncodeToString(s.salt)
+		env.Iterations = pbkdf2Iterations
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	env.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	env.Data = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, data, []byte(name)))
+
+	return json.Marshal(env)
+}
+
+// open decrypts an envelope read from the named file
+func (s *sealer) open(name string, env *envelope) ([]byte, error) {
+	if env.Encrypted != cipherAESGCM {
+		return nil, fmt.Errorf("unsupported state cipher %q", env.Encrypted)
+	}
+	if env.KDF != s.kdf {
+		return nil, fmt.Errorf("state was encrypted using %s, not %s", env.KDF, s.kdf)
+	}
+
+	aead := s.keyringKey
+	if s.kdf == kdfPBKDF2 {
+		salt, err := base64.StdEncoding.DecodeString(env.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid salt: %w", err)
+		}
+		if env.Iterations <= 0 || env.Iterations > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("invalid iteration count %d", env.Iterations)
+		}
+		if aead, err = s.derive(salt, env.Iterations); err != nil {
+			return nil, err
+		}
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+
+	data, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s (wrong passphrase or key?)", name)
+	}
+	return data, nil
+}
+
+// derive returns the cipher for a salt, caching it since PBKDF2 is deliberately slow
+func (s *sealer) derive(salt []byte, iterations int) (cipher.AEAD, error) {
+	cacheKey := fmt.Sprintf("%x/%d", salt, iterations)
+	if aead, ok := s.aeads[cacheKey]; ok {
+		return aead, nil
+	}
+
+	key, err := pbkdf2.Key(sha256.New, s.passphrase, salt, iterations, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive state key: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[cacheKey] = aead
+	return aead, nil
+}
+
+// newAEAD creates an AES-256-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
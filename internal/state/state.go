@@ -5,11 +5,13 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/bits-and-blooms/bloom/v3"
 )
@@ -23,59 +25,53 @@ const (
 	defaultFalsePositiveRate = 0.001
 )
 
+// maxFailureLength caps the error text recorded for each failed message
+const maxFailureLength = 200
+
 // ImportState tracks the progress of a PST import for resume capability
 type ImportState struct {
-	PSTPath         string          `json:"pst_path"`
-	PSTHash         string          `json:"pst_hash"`          // SHA256 of first 1MB of PST
-	Username        string          `json:"username"`          // IMAP username
-	BloomData       string          `json:"bloom_data"`        // Base64-encoded bloom filter
-	UploadedCount   int             `json:"uploaded_count"`
-	TotalCount      int             `json:"total_count"`
-	CompletedFolder map[string]bool `json:"completed_folders"` // Folders fully uploaded
+	PSTPath         string            `json:"pst_path"`
+	PSTHash         string            `json:"pst_hash"`    // SHA256 of first 1MB of PST
+	Username        string            `json:"username"`    // IMAP username
+	Destination     string            `json:"destination"` // Server the messages are uploaded to
+	BloomData       string            `json:"bloom_data"`  // Base64-encoded bloom filter
+	UploadedCount   int               `json:"uploaded_count"`
+	TotalCount      int               `json:"total_count"`
+	CompletedFolder map[string]bool   `json:"completed_folders"` // Folders fully uploaded
+	Failed          map[string]string `json:"failed,omitempty"`  // Message ID -> last upload error
+	LastRun         time.Time         `json:"last_run"`
 
 	// Runtime fields (not serialized)
 	bloomFilter *bloom.BloomFilter
+	store       *Store
+	key         string // Catalogue key for this PST/user/destination
 	statePath   string
-	isResuming  bool // True if we loaded existing progress
+	legacyPath  string // Pre-catalogue location next to the PST file
+	isResuming  bool   // True if we loaded existing progress
 	mu          sync.Mutex
 }
 
-// NewImportState creates a new import state for a PST file
-func NewImportState(pstPath, username string) (*ImportState, error) {
-	absPath, err := filepath.Abs(pstPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path: %w", err)
-	}
-
-	hash, err := hashPSTFile(absPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash PST file: %w", err)
-	}
-
-	state := &ImportState{
-		PSTPath:         absPath,
-		PSTHash:         hash,
-		Username:        username,
-		CompletedFolder: make(map[string]bool),
-		bloomFilter:     bloom.NewWithEstimates(defaultBloomCapacity, defaultFalsePositiveRate),
-	}
-
-	// State file is stored next to the PST file
-	state.statePath = absPath + ".import-state.json"
-
-	return state, nil
-}
-
 // Load loads existing state from disk if available
+// State written next to the PST by older versions is moved into the store,
+// encrypted if encryption is enabled.
 func (s *ImportState) Load() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := os.ReadFile(s.statePath)
+	legacy := false
+	data, err := s.store.readFile(s.statePath)
+	if os.IsNotExist(err) {
+		// Fall back to a state file written next to the PST by older versions
+		data, err = os.ReadFile(s.legacyPath)
+		legacy = err == nil
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil // No existing state, starting fresh
 		}
+		if errors.Is(err, ErrEncrypted) || errors.Is(err, ErrPlaintext) {
+			return err
+		}
 		return fmt.Errorf("failed to read state file: %w", err)
 	}
 
@@ -93,6 +89,10 @@ func (s *ImportState) Load() error {
 		// Different user, ignore old state
 		return nil
 	}
+	if loaded.Destination != "" && loaded.Destination != s.Destination {
+		// Different server, ignore old state
+		return nil
+	}
 
 	// Restore bloom filter from base64 data
 	if loaded.BloomData != "" {
@@ -111,16 +111,29 @@ func (s *ImportState) Load() error {
 	s.UploadedCount = loaded.UploadedCount
 	s.TotalCount = loaded.TotalCount
 	s.CompletedFolder = loaded.CompletedFolder
+	s.Failed = loaded.Failed
 
 	if s.CompletedFolder == nil {
 		s.CompletedFolder = make(map[string]bool)
 	}
+	if s.Failed == nil {
+		s.Failed = make(map[string]string)
+	}
 
 	// Mark that we're resuming a previous import
 	if s.UploadedCount > 0 || len(s.CompletedFolder) > 0 {
 		s.isResuming = true
 	}
 
+	if legacy {
+		if err := s.save(); err != nil {
+			return fmt.Errorf("failed to move state from %s: %w", s.legacyPath, err)
+		}
+		// The legacy file may sit on read-only media; the copy in the store
+		// is read first from now on
+		os.Remove(s.legacyPath)
+	}
+
 	return nil
 }
 
@@ -129,25 +142,50 @@ func (s *ImportState) Save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.save()
+}
+
+// save persists the current state to disk. Must hold s.mu.
+func (s *ImportState) save() error {
 	// Serialize bloom filter to base64
 	bloomBytes, err := s.bloomFilter.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("failed to marshal bloom filter: %w", err)
 	}
 	s.BloomData = base64.StdEncoding.EncodeToString(bloomBytes)
+	s.LastRun = time.Now()
 
 	data, err := json.Marshal(s)
 	if err != nil {
 		return fmt.Errorf("failed to serialize state: %w", err)
 	}
 
-	// Write to temp file first, then rename for atomic update
-	tmpPath := s.statePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+	if err := s.store.writeFile(s.statePath, data); err != nil {
+		return err
+	}
+
+	return s.store.updateEntry(s.entry(false))
+}
+
+// writeFileAtomic writes to a temp file first, then renames for atomic update
+// The temp file has a name of its own, so two processes saving the same
+// state can't write into each other's.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("failed to write state file: %w", err)
 	}
 
-	if err := os.Rename(tmpPath, s.statePath); err != nil {
+	if err := os.Rename(tmpPath, path); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("failed to save state file: %w", err)
 	}
@@ -155,6 +193,23 @@ func (s *ImportState) Save() error {
 	return nil
 }
 
+// entry builds the catalogue entry describing this state. Must hold s.mu.
+func (s *ImportState) entry(complete bool) CatalogueEntry {
+	return CatalogueEntry{
+		Key:           s.key,
+		PSTPath:       s.PSTPath,
+		PSTHash:       s.PSTHash,
+		Username:      s.Username,
+		Destination:   s.Destination,
+		StateFile:     filepath.Base(s.statePath),
+		UploadedCount: s.UploadedCount,
+		TotalCount:    s.TotalCount,
+		FailedCount:   len(s.Failed),
+		Complete:      complete,
+		LastRun:       s.LastRun,
+	}
+}
+
 // MarkUploaded marks a message as uploaded
 func (s *ImportState) MarkUploaded(messageID string) {
 	s.mu.Lock()
@@ -164,6 +219,27 @@ func (s *ImportState) MarkUploaded(messageID string) {
 		s.bloomFilter.AddString(messageID)
 		s.UploadedCount++
 	}
+	delete(s.Failed, messageID)
+}
+
+// MarkFailed records a message that could not be uploaded so it can be
+// reported as outstanding until a later run uploads it
+func (s *ImportState) MarkFailed(messageID string, uploadErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := uploadErr.Error()
+	if len(msg) > maxFailureLength {
+		msg = msg[:maxFailureLength]
+	}
+	s.Failed[messageID] = msg
+}
+
+// FailedCount returns the number of messages whose last upload attempt failed
+func (s *ImportState) FailedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Failed)
 }
 
 // IsUploaded checks if a message has already been uploaded
@@ -222,13 +298,12 @@ func (s *ImportState) HasExistingProgress() bool {
 	return s.UploadedCount > 0
 }
 
-// Clear removes the state file
+// Clear removes the state file and resets the catalogue entry
 func (s *ImportState) Clear() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	err := os.Remove(s.statePath)
-	if err != nil && !os.IsNotExist(err) {
+	if err := s.removeFiles(); err != nil {
 		return err
 	}
 
@@ -236,6 +311,35 @@ func (s *ImportState) Clear() error {
 	s.bloomFilter = bloom.NewWithEstimates(defaultBloomCapacity, defaultFalsePositiveRate)
 	s.UploadedCount = 0
 	s.CompletedFolder = make(map[string]bool)
+	s.Failed = make(map[string]string)
+	s.isResuming = false
+
+	return s.store.removeEntry(s.key)
+}
+
+// Finish removes the state file after a successful import, keeping the
+// catalogue entry so the completed import still shows up in status
+func (s *ImportState) Finish() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.removeFiles(); err != nil {
+		return err
+	}
+
+	s.LastRun = time.Now()
+	return s.store.updateEntry(s.entry(true))
+}
+
+// removeFiles deletes the state file and any legacy copy next to the PST
+func (s *ImportState) removeFiles() error {
+	err := os.Remove(s.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// The legacy file may sit on read-only media, so failing to remove it is not fatal
+	os.Remove(s.legacyPath)
 
 	return nil
 }